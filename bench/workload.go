@@ -0,0 +1,77 @@
+// Package bench provides a workload of representative log lines to benchmark
+// the grok package, or the grok usage of other projects
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Patterns matching the lines of GenerateWorkload
+const (
+	// ApachePattern matches the Apache combined log lines
+	ApachePattern = `%{COMBINEDAPACHELOG}`
+	// SyslogPattern matches the syslog lines
+	SyslogPattern = `%{SYSLOGBASE} %{GREEDYDATA:message}`
+)
+
+var (
+	months   = []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+	methods  = []string{"GET", "GET", "GET", "POST", "PUT", "DELETE", "HEAD"}
+	paths    = []string{"/", "/index.html", "/api/v1/users", "/api/v1/orders?page=2", "/static/app.js", "/favicon.ico"}
+	statuses = []int{200, 200, 200, 201, 204, 301, 304, 400, 403, 404, 500, 503}
+	agents   = []string{
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) Gecko/20100101 Firefox/121.0",
+		"curl/8.4.0",
+	}
+	hosts    = []string{"web-01", "web-02", "db-01", "cache-01"}
+	programs = []string{"sshd", "cron", "kernel", "systemd", "nginx"}
+	messages = []string{
+		"Accepted publickey for deploy from 10.0.0.7 port 52144 ssh2",
+		"(root) CMD (run-parts /etc/cron.hourly)",
+		"Out of memory: Killed process 4242 (java)",
+		"Started Daily apt download activities.",
+		"upstream timed out (110: Connection timed out) while reading response header",
+	}
+)
+
+// GenerateWorkload returns n log lines alternating between Apache combined
+// log lines, matched by ApachePattern, and syslog lines, matched by
+// SyslogPattern. The lines are random but the same for a given n, so that
+// benchmark runs can be compared
+func GenerateWorkload(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	lines := make([]string, n)
+	for i := range lines {
+		if i%2 == 0 {
+			lines[i] = apacheLine(r)
+		} else {
+			lines[i] = syslogLine(r)
+		}
+	}
+	return lines
+}
+
+// apacheLine returns an Apache combined log line
+func apacheLine(r *rand.Rand) string {
+	return fmt.Sprintf(`%d.%d.%d.%d - %s [%02d/%s/2024:%02d:%02d:%02d +0000] "%s %s HTTP/1.1" %d %d "%s" "%s"`,
+		10+r.Intn(200), r.Intn(256), r.Intn(256), 1+r.Intn(254),
+		pick(r, []string{"-", "frank", "alice"}),
+		1+r.Intn(28), pick(r, months), r.Intn(24), r.Intn(60), r.Intn(60),
+		pick(r, methods), pick(r, paths), statuses[r.Intn(len(statuses))], r.Intn(100000),
+		pick(r, []string{"-", "https://example.com/", "https://example.com/search?q=grok"}),
+		pick(r, agents))
+}
+
+// syslogLine returns a syslog line
+func syslogLine(r *rand.Rand) string {
+	return fmt.Sprintf("%s %2d %02d:%02d:%02d %s %s[%d]: %s",
+		pick(r, months), 1+r.Intn(28), r.Intn(24), r.Intn(60), r.Intn(60),
+		pick(r, hosts), pick(r, programs), 1+r.Intn(65535), pick(r, messages))
+}
+
+// pick returns a random element of values
+func pick(r *rand.Rand, values []string) string {
+	return values[r.Intn(len(values))]
+}
@@ -0,0 +1,81 @@
+package bench
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mishel-gc/grok"
+)
+
+func defaultStorage() grok.PatternStorage {
+	denormalized, _ := grok.DenormalizePatternsFromMap(grok.CopyDefalutPatterns())
+	return grok.PatternStorage{denormalized}
+}
+
+func TestGenerateWorkload(t *testing.T) {
+	storage := defaultStorage()
+	apache, err := grok.CompilePattern(ApachePattern, storage, grok.WithAnchorStart(), grok.WithAnchorEnd())
+	if err != nil {
+		t.Fatalf("Failed to compile %s: %v", ApachePattern, err)
+	}
+	syslog, err := grok.CompilePattern(SyslogPattern, storage, grok.WithAnchorStart(), grok.WithAnchorEnd())
+	if err != nil {
+		t.Fatalf("Failed to compile %s: %v", SyslogPattern, err)
+	}
+
+	lines := GenerateWorkload(200)
+	if len(lines) != 200 {
+		t.Fatalf("expected 200 lines, got %d", len(lines))
+	}
+	for i, line := range lines {
+		gr := apache
+		if i%2 == 1 {
+			gr = syslog
+		}
+		if !gr.Match(line) {
+			t.Errorf("line %d does not match: %s", i, line)
+		}
+	}
+
+	if strings.Join(GenerateWorkload(20), "\n") != strings.Join(lines[:20], "\n") {
+		t.Error("expected the same lines for the same n")
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	gr, err := grok.CompilePattern(ApachePattern+`|`+SyslogPattern, defaultStorage())
+	if err != nil {
+		b.Fatalf("Failed to compile pattern: %v", err)
+	}
+	lines := GenerateWorkload(1000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := gr.Run(lines[i%len(lines)], false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompilePattern(b *testing.B) {
+	storage := defaultStorage()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := grok.CompilePattern(ApachePattern, storage); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDenormalizeDefaults(b *testing.B) {
+	defaultPatterns := grok.CopyDefalutPatterns()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		grok.DenormalizePatternsFromMap(defaultPatterns)
+	}
+}
@@ -0,0 +1,86 @@
+package grok
+
+import (
+	"container/list"
+	"sync"
+)
+
+// conversionCache memoizes the typed conversions of captured values, keeping
+// the size most recently used values of each field
+type conversionCache struct {
+	mu     sync.Mutex
+	size   int
+	fields map[string]*fieldCache
+}
+
+// fieldCache is the LRU list of the conversions of one field
+type fieldCache struct {
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// conversion is a cached result of castValue
+type conversion struct {
+	value string
+	typed interface{}
+	err   error
+}
+
+// newConversionCache returns a cache keeping size values per field
+func newConversionCache(size int) *conversionCache {
+	return &conversionCache{
+		size:   size,
+		fields: map[string]*fieldCache{},
+	}
+}
+
+// get returns the cached conversion of value for field
+func (c *conversionCache) get(field, value string) (interface{}, error, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fc, ok := c.fields[field]
+	if !ok {
+		return nil, nil, false
+	}
+	e, ok := fc.entries[value]
+	if !ok {
+		return nil, nil, false
+	}
+	fc.order.MoveToFront(e)
+	conv := e.Value.(*conversion)
+	return conv.typed, conv.err, true
+}
+
+// put caches the conversion of value for field, evicting the least recently
+// used value of the field when it is full
+func (c *conversionCache) put(field, value string, typed interface{}, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fc, found := c.fields[field]
+	if !found {
+		fc = &fieldCache{
+			entries: map[string]*list.Element{},
+			order:   list.New(),
+		}
+		c.fields[field] = fc
+	}
+	if e, found := fc.entries[value]; found {
+		fc.order.MoveToFront(e)
+		return
+	}
+	fc.entries[value] = fc.order.PushFront(&conversion{value: value, typed: typed, err: err})
+	if fc.order.Len() > c.size {
+		oldest := fc.order.Back()
+		fc.order.Remove(oldest)
+		delete(fc.entries, oldest.Value.(*conversion).value)
+	}
+}
+
+// clear drops every cached conversion
+func (c *conversionCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fields = map[string]*fieldCache{}
+}
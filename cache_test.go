@@ -0,0 +1,119 @@
+package grok
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestConversionCache(t *testing.T) {
+	c := newConversionCache(2)
+
+	c.put("status", "200", int64(200), nil)
+	c.put("status", "404", int64(404), nil)
+	if v, err, found := c.get("status", "200"); !found || err != nil || v != int64(200) {
+		t.Errorf("Expected cached 200, got %v %v %v", v, err, found)
+	}
+
+	// 404 is now the least recently used value and is evicted
+	c.put("status", "500", int64(500), nil)
+	if _, _, found := c.get("status", "404"); found {
+		t.Errorf("Expected 404 to be evicted")
+	}
+	if _, _, found := c.get("status", "200"); !found {
+		t.Errorf("Expected 200 to be kept")
+	}
+
+	// Fields are cached separately, failed conversions are cached too
+	c.put("bytes", "x", nil, errors.New("invalid"))
+	if _, err, found := c.get("bytes", "x"); !found || err == nil {
+		t.Errorf("Expected cached failed conversion, got %v %v", err, found)
+	}
+	if _, _, found := c.get("status", "500"); !found {
+		t.Errorf("Expected 500 to be kept")
+	}
+
+	c.clear()
+	if _, _, found := c.get("status", "500"); found {
+		t.Errorf("Expected clear to drop cached values")
+	}
+}
+
+func TestWithConversionCache(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{INT:status:int} %{NUMBER:took:float} %{DATA:meta:json}$`, storage, WithConversionCache(8))
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		ret, err := gr.RunMapWithTypeInfo(`200 1.5 {"a":1}`, false)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if ret["status"] != int64(200) || ret["took"] != 1.5 {
+			t.Errorf("Unexpected result: %v", ret)
+		}
+		// Decoded JSON values are never shared between results
+		ret["meta"].(map[string]interface{})["a"] = "changed"
+	}
+	ret, err := gr.RunMapWithTypeInfo(`200 1.5 {"a":1}`, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ret["meta"].(map[string]interface{})["a"] != float64(1) {
+		t.Errorf("Expected a fresh JSON value, got %v", ret["meta"])
+	}
+	if _, _, found := gr.cache.get("status", "200"); !found {
+		t.Errorf("Expected status 200 to be cached")
+	}
+
+	gr.Reset()
+	if _, _, found := gr.cache.get("status", "200"); found {
+		t.Errorf("Expected Reset to clear the cache")
+	}
+}
+
+// repetitiveLines returns n access log lines drawn from a few distinct values
+func repetitiveLines(n int) []string {
+	statuses := []string{"200", "301", "404", "500"}
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%s %d %s 0.%d", statuses[i%len(statuses)], 512*(i%8), []string{"true", "false"}[i%2], i%10)
+	}
+	return lines
+}
+
+func BenchmarkConversionCache(b *testing.B) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+	lines := repetitiveLines(1000)
+
+	for _, size := range []int{0, 16} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			gr, err := CompilePattern(`%{INT:status:int} %{INT:bytes:int} %{WORD:cached:bool} %{NUMBER:took:float}`, storage, WithConversionCache(size))
+			if err != nil {
+				b.Fatalf("Failed to compile pattern: %v", err)
+			}
+			ret := make([][]string, len(lines))
+			for i, line := range lines {
+				if ret[i], err = gr.Run(line, false); err != nil {
+					b.Fatalf("Unexpected error: %v", err)
+				}
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				val := ret[i%len(ret)]
+				for _, name := range gr.MatchNames() {
+					gr.GetValCastByName(name, val)
+				}
+			}
+		})
+	}
+}
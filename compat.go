@@ -0,0 +1,160 @@
+package grok
+
+import (
+	"fmt"
+	"regexp"
+	resyntax "regexp/syntax"
+	"strings"
+)
+
+// repeatCount matches a {n}, {n,} or {n,m} repetition at the start of a string
+var repeatCount = regexp.MustCompile(`^\{[0-9]+(?:,[0-9]*)?\}`)
+
+// groupConstructs are the group syntaxes of other regular expression flavors
+// rejected by the regexp package, longest prefixes first
+var groupConstructs = []struct {
+	prefix string
+	name   string
+}{
+	{"(?<=", "lookbehind"},
+	{"(?<!", "negative lookbehind"},
+	{"(?>", "atomic group"},
+	{"(?=", "lookahead"},
+	{"(?!", "negative lookahead"},
+	{"(?P=", "named backreference"},
+	{"(?P>", "recursion"},
+	{"(?&", "recursion"},
+	{"(?R)", "recursion"},
+	{"(?(", "conditional"},
+	{"(?|", "branch reset group"},
+	{"(?#", "comment group"},
+}
+
+// checkSyntax returns an error wrapping ErrUnsupportedSyntax naming the first
+// construct of expr that the regexp package does not support, such as the
+// possessive quantifiers and atomic groups found in patterns written for
+// other grok implementations. Character classes and escaped characters are
+// skipped, other syntax errors are left to the compilation
+func checkSyntax(expr string) error {
+	unsupported := func(name, token string, offset int) error {
+		return fmt.Errorf("%w: %s `%s` at offset %d", ErrUnsupportedSyntax, name, token, offset)
+	}
+
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; c {
+		case '\\':
+			if i+1 >= len(expr) {
+				return nil
+			}
+			switch n := expr[i+1]; {
+			case n >= '1' && n <= '9':
+				return unsupported("backreference", expr[i:i+2], i)
+			case n == 'k' && i+2 < len(expr) && strings.IndexByte("<{'", expr[i+2]) >= 0:
+				return unsupported("named backreference", expr[i:i+3], i)
+			case n == 'g' || n == 'G' || n == 'Z' || n == 'K':
+				return unsupported("escape", expr[i:i+2], i)
+			}
+			i++
+		case '[':
+			i = skipClass(expr, i)
+		case '(':
+			if !strings.HasPrefix(expr[i:], "(?") {
+				continue
+			}
+			for _, g := range groupConstructs {
+				if strings.HasPrefix(expr[i:], g.prefix) {
+					return unsupported(g.name, g.prefix, i)
+				}
+			}
+			i++
+		case '*', '+', '?', '{':
+			end := i + 1
+			if c == '{' {
+				m := repeatCount.FindString(expr[i:])
+				if m == "" {
+					continue
+				}
+				end = i + len(m)
+			}
+			if end < len(expr) && expr[end] == '+' {
+				return unsupported("possessive quantifier", expr[i:end+1], i)
+			}
+			i = end - 1
+			if end < len(expr) && expr[end] == '?' {
+				i = end
+			}
+		}
+	}
+	return nil
+}
+
+// skipClass returns the offset of the ']' closing the character class that
+// starts at offset i of expr, or the end of expr when the class is not closed
+func skipClass(expr string, i int) int {
+	j := i + 1
+	if j < len(expr) && expr[j] == '^' {
+		j++
+	}
+	// A ']' right after the opening bracket is a literal
+	if j < len(expr) && expr[j] == ']' {
+		j++
+	}
+	for ; j < len(expr); j++ {
+		switch expr[j] {
+		case '\\':
+			j++
+		case '[':
+			if j+1 < len(expr) && expr[j+1] == ':' {
+				if k := strings.Index(expr[j+2:], ":]"); k >= 0 {
+					j += k + 3
+				}
+			}
+		case ']':
+			return j
+		}
+	}
+	return len(expr)
+}
+
+// largestRepeat returns the largest number of copies of an expression made by
+// the bounded repetitions of expr, nested repetitions multiplying, along with
+// the outermost repetition making them. It returns 0 when expr has no bounded
+// repetition or cannot be parsed, the error being left to the compilation
+func largestRepeat(expr string) (int, string) {
+	re, err := resyntax.Parse(expr, resyntax.Perl)
+	if err != nil {
+		return 0, ""
+	}
+
+	var max int
+	var repeat string
+	var walk func(re *resyntax.Regexp) int
+	walk = func(re *resyntax.Regexp) int {
+		inner := 1
+		for _, sub := range re.Sub {
+			if n := walk(sub); n > inner {
+				inner = n
+			}
+		}
+		if re.Op != resyntax.OpRepeat {
+			return inner
+		}
+
+		count := re.Max
+		if count < 0 {
+			count = re.Min
+		}
+		// Saturate instead of overflowing on deeply nested repetitions
+		if count > 0 && inner > int(^uint32(0))/count {
+			count = int(^uint32(0))
+		} else {
+			count *= inner
+		}
+		if count >= max {
+			max, repeat = count, re.String()
+		}
+		return count
+	}
+	walk(re)
+	return max, repeat
+}
@@ -0,0 +1,116 @@
+package grok
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckSyntax(t *testing.T) {
+	valid := []string{
+		`%{WORD:w} \d+ [[:alnum:]]+ (?:a|b)*? (?i)x (?P<n>\w{2,3})?`,
+		`[]a++] [^]?+] [a[:digit:]++] \+\+ a\++ \(?>x\) %{INT:n}{2}`,
+		`(?<name>\d+) a{1,}? b{3} c{,2}+ \\`,
+		`x\`,
+	}
+	for _, expr := range valid {
+		if err := checkSyntax(expr); err != nil {
+			t.Errorf("%s: unexpected error %v", expr, err)
+		}
+	}
+
+	invalid := []struct {
+		expr     string
+		expected string
+	}{
+		{`%{WORD:w} a++`, "possessive quantifier `++` at offset 11"},
+		{`\d*+x`, "possessive quantifier `*+` at offset 2"},
+		{`a?+`, "possessive quantifier `?+` at offset 1"},
+		{`\d{2,4}+`, "possessive quantifier `{2,4}+` at offset 2"},
+		{`x(?>abc|ab)c`, "atomic group `(?>` at offset 1"},
+		{`foo(?=bar)`, "lookahead `(?=` at offset 3"},
+		{`(?!x)`, "negative lookahead `(?!` at offset 0"},
+		{`(?<=\$)\d+`, "lookbehind `(?<=` at offset 0"},
+		{`(?<!-)\d+`, "negative lookbehind `(?<!` at offset 0"},
+		{`(a)\1`, "backreference `\\1` at offset 3"},
+		{`(?P<q>['"]).*?\k<q>`, "named backreference `\\k<` at offset 14"},
+		{`(?P<q>a)(?P=q)`, "named backreference `(?P=` at offset 8"},
+		{`line\Z`, "escape `\\Z` at offset 4"},
+		{`(?(1)a|b)`, "conditional `(?(` at offset 0"},
+	}
+	for _, tt := range invalid {
+		err := checkSyntax(tt.expr)
+		if !errors.Is(err, ErrUnsupportedSyntax) {
+			t.Errorf("%s: expected ErrUnsupportedSyntax, got %v", tt.expr, err)
+			continue
+		}
+		if !strings.HasSuffix(err.Error(), tt.expected) {
+			t.Errorf("%s: expected error ending with %q, got %q", tt.expr, tt.expected, err)
+		}
+	}
+}
+
+func TestDenormalizePatternUnsupportedSyntax(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	_, err := DenormalizePattern(`%{IP:client} (?>%{WORD:verb})`, storage)
+	if !errors.Is(err, ErrUnsupportedSyntax) || !strings.Contains(err.Error(), "atomic group `(?>` at offset 13") {
+		t.Errorf("expected an atomic group error, got %v", err)
+	}
+	if _, err := CompilePattern(`%{INT:n}++`, storage); !errors.Is(err, ErrUnsupportedSyntax) {
+		t.Errorf("expected ErrUnsupportedSyntax from CompilePattern, got %v", err)
+	}
+
+	_, invalid := DenormalizePatternsFromMap(map[string]string{
+		"POSSESSIVE": `\d++`,
+		"USES":       `%{POSSESSIVE}`,
+		"FINE":       `\d+`,
+	})
+	if !strings.Contains(invalid["POSSESSIVE"], "possessive quantifier `++` at offset 2") {
+		t.Errorf("unexpected error for POSSESSIVE: %q", invalid["POSSESSIVE"])
+	}
+	if _, ok := invalid["FINE"]; ok {
+		t.Errorf("unexpected error for FINE: %q", invalid["FINE"])
+	}
+}
+
+func TestLargestRepeat(t *testing.T) {
+	tests := []struct {
+		expr   string
+		count  int
+		repeat string
+	}{
+		{`\d+ \w*`, 0, ""},
+		{`\d{1,500}`, 500, `[0-9]{1,500}`},
+		{`a{3,} b{2}`, 3, `a{3,}`},
+		{`(?:(?:\d{1,10}){1,50}x){2}`, 1000, `(?:(?:[0-9]{1,10}){1,50}x){2}`},
+		{`(?P<n>\d{1,10}){1}`, 10, `(?P<n>[0-9]{1,10}){1}`},
+		{`a{1001}`, 0, ""},
+	}
+	for _, tt := range tests {
+		count, repeat := largestRepeat(tt.expr)
+		if count != tt.count || repeat != tt.repeat {
+			t.Errorf("%s: expected %d %q, got %d %q", tt.expr, tt.count, tt.repeat, count, repeat)
+		}
+	}
+}
+
+func TestCompilePatternMaxRepeat(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	_, err := CompilePattern(`%{WORD:id} (?P<pad>(?:\s{1,10}){1,50})`, storage, WithMaxRepeat(100))
+	if !errors.Is(err, ErrPatternTooLarge) || !strings.Contains(err.Error(), "500 times") {
+		t.Errorf("expected ErrPatternTooLarge, got %v", err)
+	}
+
+	if _, err := CompilePattern(`%{WORD:id} \d{1,100}`, storage, WithMaxRepeat(100)); err != nil {
+		t.Errorf("unexpected error for a repetition at the limit: %v", err)
+	}
+	if _, err := CompilePattern(`%{WORD:id} (?:\s{1,10}){1,50}`, storage); err != nil {
+		t.Errorf("unexpected error without limit: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package grok
+
+import (
+	"errors"
+	resyntax "regexp/syntax"
+)
+
+// RunDebug executes the compiled pattern like RunMap, and when content does
+// not match, tells how far the match got. The pattern is split into the
+// sequence of its top level elements, literals, groups and captures, the
+// content of an unnamed group such as %{NAME} being split as well. RunDebug
+// then looks for the longest prefix of that sequence matching content and
+// returns the fields captured by that prefix, the first field of the pattern
+// at or after the element where matching failed, and ErrMismatch.
+//
+// This is only a hint: the prefix may match at a place the whole pattern
+// would not, and a failing element without field, such as a literal, is
+// reported through the next field. The field is "" when no field follows.
+// The prefixes are compiled by the RegexEngine of the pattern, written in the
+// syntax of the regexp package, so a pattern using constructs that package
+// cannot parse reports no field on mismatch
+func (g *GrokRegexp) RunDebug(content string) (map[string]string, string, error) {
+	ret, err := g.RunMap(content, false)
+	if err == nil || !errors.Is(err, ErrMismatch) || g.grokPattern == nil {
+		return ret, "", err
+	}
+
+	re, perr := resyntax.Parse(g.grokPattern.denormalized, resyntax.Perl)
+	if perr != nil {
+		return nil, "", err
+	}
+	elems := sequence(re)
+	content, _ = g.input(content)
+
+	matched := map[string]string{}
+	k := 0
+	for ; k < len(elems); k++ {
+		prefix, ok := g.matchPrefix(elems[:k+1], content)
+		if !ok {
+			break
+		}
+		matched = prefix
+	}
+
+	failing := ""
+	for _, elem := range elems[k:] {
+		if names := captureNames(elem); len(names) > 0 {
+			failing = g.outputName(names[0])
+			break
+		}
+	}
+	return matched, failing, ErrMismatch
+}
+
+// matchPrefix matches the concatenation of elems against content and returns
+// the named fields it captured
+func (g *GrokRegexp) matchPrefix(elems []*resyntax.Regexp, content string) (map[string]string, bool) {
+	expr := &resyntax.Regexp{Op: resyntax.OpConcat, Sub: elems}
+	pattern := expr.String()
+	if g.opts.anchorStart {
+		pattern = `\A(?:` + pattern + `)`
+	}
+	re, err := g.opts.regexEngine().Compile(pattern)
+	if err != nil {
+		return nil, false
+	}
+	m := re.FindStringSubmatchIndex(content)
+	if m == nil {
+		return nil, false
+	}
+
+	ret := map[string]string{}
+	for i, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		// Branches of an alternation may share a name, keep the one that matched
+		if m[2*i] == -1 {
+			if _, ok := ret[g.outputName(name)]; !ok {
+				ret[g.outputName(name)] = ""
+			}
+			continue
+		}
+		ret[g.outputName(name)] = content[m[2*i]:m[2*i+1]]
+	}
+	return ret, true
+}
+
+// sequence returns the top level elements of re, the elements of unnamed
+// groups being part of the sequence
+func sequence(re *resyntax.Regexp) []*resyntax.Regexp {
+	switch {
+	case re.Op == resyntax.OpConcat:
+		var ret []*resyntax.Regexp
+		for _, sub := range re.Sub {
+			ret = append(ret, sequence(sub)...)
+		}
+		return ret
+	case re.Op == resyntax.OpCapture && re.Name == "":
+		return sequence(re.Sub[0])
+	}
+	return []*resyntax.Regexp{re}
+}
+
+// captureNames returns the names of the capture groups of re in order
+func captureNames(re *resyntax.Regexp) []string {
+	var names []string
+	if re.Op == resyntax.OpCapture && re.Name != "" {
+		names = append(names, re.Name)
+	}
+	for _, sub := range re.Sub {
+		names = append(names, captureNames(sub)...)
+	}
+	return names
+}
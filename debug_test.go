@@ -0,0 +1,81 @@
+package grok
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGrokRegexpRunDebug(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{COMMONAPACHELOG} took=%{NUMBER:took}ms`, storage, WithAnchorStart())
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	line := `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`
+
+	matched, failing, err := gr.RunDebug(line + " took=12ms")
+	if err != nil || failing != "" || matched["took"] != "12" {
+		t.Errorf("expected a full match, got %v %q %v", matched, failing, err)
+	}
+
+	tests := []struct {
+		content  string
+		failing  string
+		expected map[string]string
+	}{
+		{line + " took=fast", "took", map[string]string{"clientip": "127.0.0.1", "response": "200", "bytes": "2326"}},
+		{line + " in 12ms", "took", map[string]string{"response": "200"}},
+		{`127.0.0.1 - frank [yesterday] "GET / HTTP/1.0" 200 1 took=1ms`, "timestamp", map[string]string{"clientip": "127.0.0.1", "auth": "frank"}},
+		{`??? - frank`, "clientip", map[string]string{}},
+	}
+	for _, tt := range tests {
+		matched, failing, err := gr.RunDebug(tt.content)
+		if !errors.Is(err, ErrMismatch) {
+			t.Fatalf("%s: expected ErrMismatch, got %v", tt.content, err)
+		}
+		if failing != tt.failing {
+			t.Errorf("%s: expected failing field %q, got %q", tt.content, tt.failing, failing)
+		}
+		for k, v := range tt.expected {
+			if matched[k] != v {
+				t.Errorf("%s: expected %s=%q, got %q", tt.content, k, v, matched[k])
+			}
+		}
+		if _, ok := matched[tt.failing]; ok && tt.failing != "" {
+			t.Errorf("%s: the failing field should not be matched", tt.content)
+		}
+	}
+
+	gr.Rename("took", "duration")
+	if _, failing, _ := gr.RunDebug(line + " took=x"); failing != "duration" {
+		t.Errorf("expected the renamed failing field, got %q", failing)
+	}
+}
+
+func TestGrokRegexpRunDebugEngine(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	engine := &foldEngine{}
+	gr, err := CompilePattern(`^level=INFO %{WORD:msg} code=%{INT:code}$`, storage, WithRegexEngine(engine))
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	// The prefixes are matched case-insensitively, like the whole pattern
+	matched, failing, err := gr.RunDebug("LEVEL=info started code=none")
+	if !errors.Is(err, ErrMismatch) {
+		t.Fatalf("expected ErrMismatch, got %v", err)
+	}
+	if failing != "code" || matched["msg"] != "started" {
+		t.Errorf("unexpected result %v %q", matched, failing)
+	}
+	if engine.compiled < 2 {
+		t.Errorf("expected the prefixes to be compiled by the engine, got %d compilations", engine.compiled)
+	}
+}
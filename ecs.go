@@ -0,0 +1,119 @@
+package grok
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ecsReference matches a reference whose alias may be an ECS field such
+	// as [source][address]
+	ecsReference = regexp.MustCompile(`%\{(\w+(?:[-.]\w+)*)(?::((?:\[[^\]}]+\])+|[@\w.-]+))?(?::(\w+))?\}`)
+	// ecsGroup matches a named group whose name may be an ECS field
+	ecsGroup = regexp.MustCompile(`\(\?P?<((?:\[[^\]>]+\])+|[@\w.-]+)>`)
+)
+
+// LoadECSPatterns loads the pattern files of dir written in the format of the
+// Elastic Common Schema (ECS) patterns shipped with Logstash and Elasticsearch,
+// such as their ecs-v1 directory. Every regular file of dir is read like
+// LoadPatternsFromReader, in name order, a later definition overriding an
+// earlier one; hidden files and subdirectories are skipped.
+//
+// Field names are written as dotted ECS names: %{IP:[source][address]} becomes
+// %{IP:source.address}, which denormalization turns into the source_address
+// capture, or into the name returned by the sanitizer of WithNameSanitizer.
+// Named groups such as (?<[source][port]>\d+) get the capture name right away,
+// so the options given to LoadECSPatterns should also be given when
+// denormalizing the patterns.
+//
+// The following constructs are not supported:
+//   - fields under [@metadata] are matched without being captured
+//   - definitions using a syntax the regexp package does not support, such as
+//     lookarounds, atomic groups or possessive quantifiers, are skipped unless
+//     WithForeignSyntax is given. The patterns referencing them then fail to
+//     denormalize
+//   - type conversions other than those of the package fail to denormalize
+func LoadECSPatterns(dir string, opts ...DenormalizeOption) (map[string]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	o := newDenormalizeOptions(opts)
+	ret := map[string]string{}
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if err := loadECSFile(filepath.Join(dir, entry.Name()), ret); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, def := range ret {
+		def = ecsDefinition(def, &o)
+		if !o.foreignSyntax && checkSyntax(def) != nil {
+			delete(ret, name)
+			continue
+		}
+		ret[name] = def
+	}
+	return ret, nil
+}
+
+// loadECSFile adds the definitions of the pattern file path to m
+func loadECSFile(path string, m map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	l := &patternLoader{patterns: m}
+	if err := l.load(f, path, filepath.Dir(path)); err != nil {
+		return fmt.Errorf("ecs patterns: %w", err)
+	}
+	return nil
+}
+
+// ecsDefinition rewrites the ECS field names of def
+func ecsDefinition(def string, o *denormalizeOptions) string {
+	def = ecsReference.ReplaceAllStringFunc(def, func(ref string) string {
+		m := ecsReference.FindStringSubmatch(ref)
+		syntax, field, dtype := m[1], m[2], m[3]
+		if field == "" {
+			return ref
+		}
+		if field = ecsField(field); field == "" {
+			return "%{" + syntax + "}"
+		}
+		if dtype != "" {
+			return "%{" + syntax + ":" + field + ":" + dtype + "}"
+		}
+		return "%{" + syntax + ":" + field + "}"
+	})
+
+	return ecsGroup.ReplaceAllStringFunc(def, func(group string) string {
+		field := ecsField(ecsGroup.FindStringSubmatch(group)[1])
+		if field == "" {
+			return "(?:"
+		}
+		return "(?P<" + o.sanitizeName(field) + ">"
+	})
+}
+
+// ecsField returns the dotted form of an ECS field name written with
+// brackets, such as [source][address], or "" for a [@metadata] field
+func ecsField(field string) string {
+	if strings.HasPrefix(field, "[") {
+		field = strings.Join(strings.Split(strings.Trim(field, "[]"), "]["), ".")
+	}
+	if strings.HasPrefix(field, "@metadata") {
+		return ""
+	}
+	return field
+}
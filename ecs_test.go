@@ -0,0 +1,102 @@
+package grok
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadECSPatterns(t *testing.T) {
+	dir := writePatternFiles(t, map[string]string{
+		"grok-patterns": "# ECS patterns\n" +
+			"HOSTPORT %{IPORHOST:[destination][address]}:%{POSINT:[destination][port]:int}\n" +
+			"REQUEST %{WORD:[http][request][method]} %{NOTSPACE:[url][original]} %{DATA:[@metadata][rest]}\n",
+		"httpd": "HTTPDLOG %{HOSTPORT} %{REQUEST} (?<[http][response][status_code]>\\d{3})\n" +
+			"ATOMIC (?>a+)b\n",
+		".hidden":    "HIDDEN x\n",
+		"sub/nested": "NESTED x\n",
+	})
+
+	m, err := LoadECSPatterns(dir)
+	if err != nil {
+		t.Fatalf("LoadECSPatterns failed: %v", err)
+	}
+	expected := map[string]string{
+		"HOSTPORT": `%{IPORHOST:destination.address}:%{POSINT:destination.port:int}`,
+		"REQUEST":  `%{WORD:http.request.method} %{NOTSPACE:url.original} %{DATA}`,
+		"HTTPDLOG": `%{HOSTPORT} %{REQUEST} (?P<http_response_status_code>\d{3})`,
+	}
+	if len(m) != len(expected) {
+		t.Errorf("expected %d patterns, got %v", len(expected), m)
+	}
+	for name, def := range expected {
+		if m[name] != def {
+			t.Errorf("%s: expected %q, got %q", name, def, m[name])
+		}
+	}
+
+	patterns := CopyDefalutPatterns()
+	for name, def := range m {
+		patterns[name] = def
+	}
+	denormalized, invalid := DenormalizePatternsFromMap(patterns)
+	if len(invalid) > 0 {
+		t.Fatalf("unexpected invalid patterns %v", invalid)
+	}
+	gr, err := CompilePattern("%{HTTPDLOG}", PatternStorage{denormalized})
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	values, err := gr.RunMap("10.0.0.1:8080 GET /index.html trailing 200", false)
+	if err != nil {
+		t.Fatalf("RunMap failed: %v", err)
+	}
+	for field, value := range map[string]string{
+		"destination_address":       "10.0.0.1",
+		"destination_port":          "8080",
+		"http_request_method":       "GET",
+		"url_original":              "/index.html",
+		"http_response_status_code": "200",
+	} {
+		if values[field] != value {
+			t.Errorf("%s: expected %q, got %q", field, value, values[field])
+		}
+	}
+	if len(values) != 5 {
+		t.Errorf("unexpected fields %v", values)
+	}
+}
+
+func TestLoadECSPatternsNameSanitizer(t *testing.T) {
+	dir := writePatternFiles(t, map[string]string{
+		"patterns": "PAIR %{WORD:[user][name]} (?<[user][id]>\\d+)\n",
+	})
+	camel := func(name string) string {
+		parts := strings.Split(name, ".")
+		for i := 1; i < len(parts); i++ {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+		return strings.Join(parts, "")
+	}
+
+	m, err := LoadECSPatterns(dir, WithNameSanitizer(camel))
+	if err != nil {
+		t.Fatalf("LoadECSPatterns failed: %v", err)
+	}
+	if m["PAIR"] != `%{WORD:user.name} (?P<userId>\d+)` {
+		t.Errorf("unexpected definition %q", m["PAIR"])
+	}
+
+	gP, err := DenormalizePatternWithOptions(m["PAIR"], PatternStorage{map[string]*GrokPattern{
+		"WORD": {pattern: `\w+`, denormalized: `\w+`},
+	}}, WithNameSanitizer(camel))
+	if err != nil {
+		t.Fatalf("DenormalizePatternWithOptions failed: %v", err)
+	}
+	if gP.Denormalized() != `(?P<userName>\w+) (?P<userId>\d+)` {
+		t.Errorf("unexpected denormalized pattern %q", gP.Denormalized())
+	}
+
+	if _, err := LoadECSPatterns(dir + "/missing"); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
@@ -0,0 +1,33 @@
+package grok
+
+import "regexp"
+
+// RegexEngine compiles the denormalized regular expressions of grok patterns.
+// The default engine is the regexp package of the standard library, another
+// engine can be set with WithRegexEngine, e.g. to support constructs such as
+// backreferences that regexp does not implement
+type RegexEngine interface {
+	Compile(expr string) (CompiledRegex, error)
+}
+
+// CompiledRegex is a regular expression compiled by a RegexEngine. The
+// methods follow the semantics of their *regexp.Regexp counterparts, which
+// implements the interface. Submatch indexes are byte offsets in the content
+type CompiledRegex interface {
+	MatchString(s string) bool
+	FindStringSubmatchIndex(s string) []int
+	FindAllStringSubmatchIndex(s string, n int) [][]int
+	SubexpNames() []string
+}
+
+// stdEngine is the RegexEngine backed by the regexp package
+type stdEngine struct{}
+
+// Compile compiles expr with regexp.Compile
+func (stdEngine) Compile(expr string) (CompiledRegex, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return re, nil
+}
@@ -0,0 +1,142 @@
+package grok
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// foldEngine compiles expressions case-insensitively and counts compilations
+type foldEngine struct {
+	compiled int
+}
+
+func (e *foldEngine) Compile(expr string) (CompiledRegex, error) {
+	e.compiled++
+	return regexp.Compile("(?i)" + expr)
+}
+
+// backrefEngine records the expressions it compiles and stands in for an
+// engine supporting backreferences by matching \1 as a word character
+type backrefEngine struct {
+	exprs []string
+}
+
+func (e *backrefEngine) Compile(expr string) (CompiledRegex, error) {
+	e.exprs = append(e.exprs, expr)
+	return regexp.Compile(strings.Replace(expr, `\1`, `\w`, -1))
+}
+
+// lookaheadEngine stands in for an engine supporting lookaheads by dropping
+// the (?=USD) lookahead before compiling
+type lookaheadEngine struct{}
+
+func (lookaheadEngine) Compile(expr string) (CompiledRegex, error) {
+	return regexp.Compile(strings.Replace(expr, `(?=USD)`, "", -1))
+}
+
+type failingEngine struct{}
+
+var errEngine = errors.New("engine failure")
+
+func (failingEngine) Compile(expr string) (CompiledRegex, error) {
+	return nil, errEngine
+}
+
+func TestWithRegexEngine(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	engine := &foldEngine{}
+	gr, err := CompilePattern(`^level=INFO %{WORD:msg}$`, storage, WithRegexEngine(engine))
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if engine.compiled != 1 {
+		t.Errorf("Expected the engine to compile once, got %d", engine.compiled)
+	}
+
+	ret, err := gr.RunMap("LEVEL=info started", false)
+	if err != nil {
+		t.Fatalf("Expected the custom engine to match case-insensitively: %v", err)
+	}
+	if ret["msg"] != "started" {
+		t.Errorf("Expected msg started, got %q", ret["msg"])
+	}
+
+	std, err := CompilePattern(`^level=INFO %{WORD:msg}$`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if std.Match("LEVEL=info started") {
+		t.Errorf("Expected the default engine to be case sensitive")
+	}
+
+	if _, err := CompilePattern(`%{WORD:msg}`, storage, WithRegexEngine(failingEngine{})); !errors.Is(err, errEngine) {
+		t.Errorf("Expected the engine error to be wrapped, got %v", err)
+	}
+}
+
+func TestWithRegexEngineForeignSyntax(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	if _, err := CompilePattern(`(\w)\1 %{WORD:x}`, storage); !errors.Is(err, ErrUnsupportedSyntax) {
+		t.Errorf("Expected ErrUnsupportedSyntax with the default engine, got %v", err)
+	}
+
+	engine := &backrefEngine{}
+	gr, err := CompilePattern(`(\w)\1 %{WORD:x}`, storage, WithRegexEngine(engine))
+	if err != nil {
+		t.Fatalf("Expected the custom engine to accept a backreference: %v", err)
+	}
+	if len(engine.exprs) != 1 || !strings.HasPrefix(engine.exprs[0], `(\w)\1 `) {
+		t.Errorf("Expected the engine to receive the backreference, got %q", engine.exprs)
+	}
+	if ret, err := gr.RunMap("aa word", false); err != nil || ret["x"] != "word" {
+		t.Errorf("Unexpected result %v %v", ret, err)
+	}
+
+	if _, _, err := CompilePatternVerbose(`(\w)\1 %{WORD:x}`, storage, WithRegexEngine(&backrefEngine{})); err != nil {
+		t.Errorf("Expected CompilePatternVerbose to accept a backreference: %v", err)
+	}
+}
+
+func TestWithForeignSyntax(t *testing.T) {
+	defs := map[string]string{"PRICE": `\d+(?=USD)`}
+
+	if _, invalid := DenormalizePatternsFromMap(defs); len(invalid) != 1 {
+		t.Errorf("Expected the lookahead to be rejected by default, got %v", invalid)
+	}
+
+	valid, invalid := DenormalizePatternsFromMapWithOptions(defs, nil, WithForeignSyntax())
+	if len(invalid) != 0 {
+		t.Fatalf("Expected the lookahead to be accepted: %v", invalid)
+	}
+	storage := PatternStorage{valid}
+
+	gr, err := CompilePattern(`total=%{PRICE:p}`, storage, WithRegexEngine(lookaheadEngine{}))
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if ret, err := gr.RunMap("total=42USD", false); err != nil || ret["p"] != "42" {
+		t.Errorf("Unexpected result %v %v", ret, err)
+	}
+
+	if _, err := storage.Update("PRICE", `\d+(?:\.\d+)?(?=USD)`); err == nil {
+		t.Error("Expected Update to reject the lookahead without the option")
+	}
+	if _, err := storage.Update("PRICE", `\d+(?:\.\d+)?(?=USD)`, WithForeignSyntax()); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	gr, err = CompilePattern(`total=%{PRICE:p}`, storage, WithRegexEngine(lookaheadEngine{}))
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if ret, err := gr.RunMap("total=4.5USD", false); err != nil || ret["p"] != "4.5" {
+		t.Errorf("Unexpected result %v %v", ret, err)
+	}
+}
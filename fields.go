@@ -0,0 +1,71 @@
+package grok
+
+import (
+	"fmt"
+)
+
+// MatchFields validates fields already split by the caller, such as the
+// columns of a CSV record, each against the pattern of the same index, which
+// must match the whole field. The value returned for a field is the typed
+// value of the only field of its pattern, e.g. int64(200) for "%{INT:n:int}",
+// the field itself for a pattern without field such as "%{IP}", and a map of
+// the typed values keyed by field name for a pattern with several fields. A
+// typed field that did not participate in the match, such as an optional
+// one, is nil.
+//
+// The values and errors are in the order of fields. The error of a valid
+// field is nil, the value of an invalid one is nil and its error wraps
+// ErrMismatch, a *ConversionError, or the error of the compilation of its
+// pattern. A field without pattern is invalid. Each pattern is compiled once
+// per call, so a caller validating many records should compile the patterns
+// itself with WithAnchorStart and WithAnchorEnd
+func MatchFields(fields []string, patterns []string, storage PatternStorageIface) ([]interface{}, []error) {
+	values := make([]interface{}, len(fields))
+	errs := make([]error, len(fields))
+
+	compiled := map[string]*GrokRegexp{}
+	for i, field := range fields {
+		if i >= len(patterns) {
+			errs[i] = fmt.Errorf("field %d: no pattern", i)
+			continue
+		}
+
+		gr, ok := compiled[patterns[i]]
+		if !ok {
+			var err error
+			gr, err = CompilePattern(patterns[i], storage, WithAnchorStart(), WithAnchorEnd())
+			if err != nil {
+				errs[i] = fmt.Errorf("field %d: %w", i, err)
+				continue
+			}
+			compiled[patterns[i]] = gr
+		}
+
+		values[i], errs[i] = matchField(gr, field)
+		if errs[i] != nil {
+			errs[i] = fmt.Errorf("field %d: %w", i, errs[i])
+		}
+	}
+	return values, errs
+}
+
+// matchField matches field against gr and returns its value for MatchFields
+func matchField(gr *GrokRegexp, field string) (interface{}, error) {
+	typed, err := gr.RunWithTypeInfoStrict(field, false)
+	if err != nil {
+		return nil, err
+	}
+
+	switch names := gr.MatchNames(); len(names) {
+	case 0:
+		return field, nil
+	case 1:
+		return typed[0], nil
+	default:
+		ret := make(map[string]interface{}, len(names))
+		for i, name := range names {
+			ret[gr.outputName(name)] = typed[i]
+		}
+		return ret, nil
+	}
+}
@@ -0,0 +1,66 @@
+package grok
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMatchFields(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	patterns := []string{`%{IP}`, `%{INT:status:int}`, `%{WORD:verb} %{NUMBER:took:float}`, `%{WORD}`}
+
+	values, errs := MatchFields([]string{"10.0.0.1", "200", "GET 0.5", "alice"}, patterns, storage)
+	expected := []interface{}{
+		"10.0.0.1",
+		int64(200),
+		map[string]interface{}{"verb": "GET", "took": 0.5},
+		"alice",
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("unexpected values %#v", values)
+	}
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("field %d: unexpected error %v", i, err)
+		}
+	}
+
+	// A field must match its pattern as a whole
+	values, errs = MatchFields([]string{"10.0.0.1x", "200", "GET", "alice", "extra"}, patterns, storage)
+	if !errors.Is(errs[0], ErrMismatch) || values[0] != nil {
+		t.Errorf("expected ErrMismatch for a partial match, got %v %v", values[0], errs[0])
+	}
+	if errs[1] != nil || values[1] != int64(200) {
+		t.Errorf("unexpected result %v %v", values[1], errs[1])
+	}
+	if !errors.Is(errs[2], ErrMismatch) {
+		t.Errorf("expected ErrMismatch, got %v", errs[2])
+	}
+	if errs[4] == nil {
+		t.Error("expected an error for a field without pattern")
+	}
+
+	_, errs = MatchFields([]string{"x"}, []string{`%{NOPE}`}, storage)
+	if errs[0] == nil {
+		t.Error("expected a compilation error")
+	}
+
+	// An optional typed field that did not participate is nil
+	values, errs = MatchFields([]string{"-", "GET -"}, []string{`(?:%{INT:bytes:int}|-)`, `%{WORD:verb} (?:%{NUMBER:took:float}|-)`}, storage)
+	if errs[0] != nil || values[0] != nil {
+		t.Errorf("Expected a nil value without error, got %v %v", values[0], errs[0])
+	}
+	if errs[1] != nil || !reflect.DeepEqual(values[1], map[string]interface{}{"verb": "GET", "took": nil}) {
+		t.Errorf("Expected took to be nil without error, got %v %v", values[1], errs[1])
+	}
+
+	_, errs = MatchFields([]string{"99999999999999999999"}, []string{`%{INT:n:int}`}, storage)
+	var convErr *ConversionError
+	if !errors.As(errs[0], &convErr) {
+		t.Errorf("expected a *ConversionError, got %v", errs[0])
+	}
+}
@@ -0,0 +1,59 @@
+package grok
+
+import (
+	resyntax "regexp/syntax"
+)
+
+// FieldNode is a field of a compiled pattern along with the fields captured
+// inside of it
+type FieldNode struct {
+	Name     string
+	Type     string // declared type, "" when the field has no annotation
+	Children []FieldNode
+}
+
+// FieldTree returns the fields of the pattern nested as their capture groups
+// are in the denormalized regular expression, e.g. %{SYSLOGPROG:prog} gives a
+// prog node whose children are the program and pid fields of SYSLOGPROG. Fields
+// are named as in the results and listed in the order of the pattern. A name
+// shared by the branches of an alternation makes a single node. The field set
+// by WithFullMatchField holds the whole match, so it is the only root node and
+// the other fields are its children
+func (g *GrokRegexp) FieldTree() []FieldNode {
+	if g.grokPattern == nil {
+		return nil
+	}
+	re, err := resyntax.Parse(g.grokPattern.denormalized, resyntax.Perl)
+	if err != nil {
+		return nil
+	}
+	nodes := g.fieldNodes(re, nil)
+	if g.opts.fullMatchField != "" {
+		nodes = []FieldNode{{Name: g.outputName(g.opts.fullMatchField), Children: nodes}}
+	}
+	return nodes
+}
+
+// fieldNodes appends to nodes the fields captured by re outside of any
+// nested named group
+func (g *GrokRegexp) fieldNodes(re *resyntax.Regexp, nodes []FieldNode) []FieldNode {
+	if re.Op != resyntax.OpCapture || re.Name == "" {
+		for _, sub := range re.Sub {
+			nodes = g.fieldNodes(sub, nodes)
+		}
+		return nodes
+	}
+
+	name := g.outputName(re.Name)
+	for i := range nodes {
+		if nodes[i].Name == name {
+			nodes[i].Children = g.fieldNodes(re.Sub[0], nodes[i].Children)
+			return nodes
+		}
+	}
+	return append(nodes, FieldNode{
+		Name:     name,
+		Type:     g.grokPattern.varbType[re.Name],
+		Children: g.fieldNodes(re.Sub[0], nil),
+	})
+}
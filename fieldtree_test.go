@@ -0,0 +1,63 @@
+package grok
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGrokRegexpFieldTree(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	defaultPatterns["ENDPOINT"] = `(?:%{IPV4:host}|%{HOSTNAME:host}):%{POSINT:port:int}`
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{ENDPOINT:addr} %{SYSLOGPROG:prog}: (?P<msg>%{WORD:verb} %{NUMBER:took:float})`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	gr.Rename("took", "duration")
+
+	expected := []FieldNode{
+		{Name: "addr", Children: []FieldNode{
+			{Name: "host"},
+			{Name: "port", Type: GTypeInt},
+		}},
+		{Name: "prog", Children: []FieldNode{
+			{Name: "program"},
+			{Name: "pid"},
+		}},
+		{Name: "msg", Children: []FieldNode{
+			{Name: "verb"},
+			{Name: "duration", Type: GTypeFloat},
+		}},
+	}
+	if tree := gr.FieldTree(); !reflect.DeepEqual(tree, expected) {
+		t.Errorf("unexpected field tree\n%+v\nexpected\n%+v", tree, expected)
+	}
+
+	// The full match field encloses the other fields
+	gr, err = CompilePattern(`%{WORD:verb} %{NUMBER:took:float}`, storage, WithFullMatchField("line"))
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	expected = []FieldNode{
+		{Name: "line", Children: []FieldNode{
+			{Name: "verb"},
+			{Name: "took", Type: GTypeFloat},
+		}},
+	}
+	if tree := gr.FieldTree(); !reflect.DeepEqual(tree, expected) {
+		t.Errorf("unexpected field tree\n%+v\nexpected\n%+v", tree, expected)
+	}
+	if names := gr.MatchNames(); len(names) != 3 || names[2] != "line" {
+		t.Errorf("expected the tree to cover the fields of Run, got %v", names)
+	}
+
+	gr, err = CompilePattern(`%{WORD} \d+`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if tree := gr.FieldTree(); len(tree) != 0 {
+		t.Errorf("expected an empty tree, got %+v", tree)
+	}
+}
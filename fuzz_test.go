@@ -0,0 +1,38 @@
+//go:build go1.18
+// +build go1.18
+
+package grok
+
+import "testing"
+
+func FuzzDenormalizePattern(f *testing.F) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	for _, seed := range []string{
+		"%{IP:server} %{NUMBER:port:int}",
+		"%{COMMONAPACHELOG}",
+		"%{WORD:a:b:c}",
+		"%{WORD::int}",
+		"%{:x}",
+		"%{%{WORD}}",
+		"%{WORD",
+		"}%{",
+		"((((%{INT})))",
+		"%{WORD:a}|%{INT:a:int}",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		gp, err := DenormalizePattern(input, storage)
+		if err != nil {
+			return
+		}
+		if gp == nil {
+			t.Fatal("DenormalizePattern returned neither a pattern nor an error")
+		}
+		_, _ = CompilePattern(input, storage)
+	})
+}
@@ -0,0 +1,110 @@
+package grok
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// KVOptions configures how ParseKV splits key=value pairs. The zero value
+// parses pairs separated by whitespace, with "=" between key and value,
+// values optionally quoted with double or single quotes and backslash escapes
+type KVOptions struct {
+	// FieldSeparator separates the pairs, runs of whitespace when empty
+	FieldSeparator string
+	// ValueSeparator separates a key from its value, "=" when empty
+	ValueSeparator string
+	// Quotes are the characters that can quote a value, `"'` when empty
+	Quotes string
+	// Escape makes the next character literal in a value, '\' when zero.
+	// Escapes are kept as is when it is negative
+	Escape rune
+}
+
+// ParseKV extracts the key=value pairs of content, such as the trailing
+// `user=alice action="log in"` part of a log line matched by a grok pattern,
+// for keys that are not known in advance. A quoted value ends at the matching
+// quote and may contain separators. Tokens without value separator are
+// skipped, and the last value is kept for a key given several times
+func ParseKV(content string, opts KVOptions) map[string]string {
+	if opts.ValueSeparator == "" {
+		opts.ValueSeparator = "="
+	}
+	if opts.Quotes == "" {
+		opts.Quotes = `"'`
+	}
+	if opts.Escape == 0 {
+		opts.Escape = '\\'
+	}
+
+	ret := map[string]string{}
+	for i := 0; i < len(content); {
+		i += separatorLen(content[i:], opts.FieldSeparator)
+		start := i
+		for i < len(content) && !strings.HasPrefix(content[i:], opts.ValueSeparator) && separatorLen(content[i:], opts.FieldSeparator) == 0 {
+			i++
+		}
+		if i >= len(content) || !strings.HasPrefix(content[i:], opts.ValueSeparator) {
+			continue
+		}
+		key := strings.TrimSpace(content[start:i])
+		i += len(opts.ValueSeparator)
+
+		var value string
+		value, i = kvValue(content, i, opts)
+		if key != "" {
+			ret[key] = value
+		}
+	}
+	return ret
+}
+
+// kvValue reads the value starting at offset i of content and returns it
+// along with the offset following it
+func kvValue(content string, i int, opts KVOptions) (string, int) {
+	var quote rune
+	if r, size := utf8.DecodeRuneInString(content[i:]); i < len(content) && strings.ContainsRune(opts.Quotes, r) {
+		quote = r
+		i += size
+	}
+
+	var b strings.Builder
+	for i < len(content) {
+		r, size := utf8.DecodeRuneInString(content[i:])
+		switch {
+		case r == opts.Escape && i+size < len(content):
+			next, nsize := utf8.DecodeRuneInString(content[i+size:])
+			b.WriteRune(next)
+			i += size + nsize
+			continue
+		case quote != 0 && r == quote:
+			return b.String(), i + size
+		case quote == 0 && separatorLen(content[i:], opts.FieldSeparator) > 0:
+			return b.String(), i
+		}
+		b.WriteRune(r)
+		i += size
+	}
+	return b.String(), i
+}
+
+// separatorLen returns the length of the field separator at the start of s,
+// 0 when s does not start with it. An empty separator stands for a run of
+// whitespace
+func separatorLen(s, sep string) int {
+	if sep != "" {
+		if strings.HasPrefix(s, sep) {
+			return len(sep)
+		}
+		return 0
+	}
+	n := 0
+	for n < len(s) {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		n += size
+	}
+	return n
+}
@@ -0,0 +1,81 @@
+package grok
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKV(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		opts     KVOptions
+		expected map[string]string
+	}{
+		{
+			name:     "defaults",
+			content:  `user=alice action="log in" path='/a b' empty= n=1`,
+			expected: map[string]string{"user": "alice", "action": "log in", "path": "/a b", "empty": "", "n": "1"},
+		},
+		{
+			name:     "escapes",
+			content:  `msg="say \"hi\"" file=C:\\tmp raw=a\ b`,
+			expected: map[string]string{"msg": `say "hi"`, "file": `C:\tmp`, "raw": "a b"},
+		},
+		{
+			name:     "tokens without value and repeated keys",
+			content:  "  GET /index  k=1\tflag k=2 =orphan ",
+			expected: map[string]string{"k": "2"},
+		},
+		{
+			name:     "custom separators",
+			content:  "a: 1; b: two words; c: 'x;y'",
+			opts:     KVOptions{FieldSeparator: "; ", ValueSeparator: ": "},
+			expected: map[string]string{"a": "1", "b": "two words", "c": "x;y"},
+		},
+		{
+			name:     "custom quotes and no escape",
+			content:  `a=|x y| b=c\d e="f`,
+			opts:     KVOptions{Quotes: "|", Escape: -1},
+			expected: map[string]string{"a": "x y", "b": `c\d`, "e": `"f`},
+		},
+		{
+			name:     "unterminated quote",
+			content:  `a=1 b="open value`,
+			expected: map[string]string{"a": "1", "b": "open value"},
+		},
+		{
+			name:     "empty",
+			content:  "",
+			expected: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseKV(tt.content, tt.opts)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseKVWithGrok(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{SYSLOGTIMESTAMP:ts} %{WORD:app}: %{GREEDYDATA:kv}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	ret, err := gr.RunMap(`Jan  1 12:00:00 auth: user=bob result="access denied" attempts=3`, false)
+	if err != nil {
+		t.Fatalf("RunMap failed: %v", err)
+	}
+	kv := ParseKV(ret["kv"], KVOptions{})
+	if kv["user"] != "bob" || kv["result"] != "access denied" || kv["attempts"] != "3" {
+		t.Errorf("unexpected pairs %v", kv)
+	}
+}
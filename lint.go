@@ -0,0 +1,160 @@
+package grok
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	greedyPattern = regexp.MustCompile(`(?:\.[*+]|%{GREEDYDATA(?::[^}]*)?})\??(?:\.[*+]|%{GREEDYDATA(?::[^}]*)?})`)
+	inlinePattern = regexp.MustCompile(`\(\?P?<(\w+)>`)
+)
+
+// adjacentPatterns are the patterns matching runs of digits or word
+// characters, two of them in a row without a separator split ambiguously
+var adjacentPatterns = map[string]bool{
+	"INT":           true,
+	"NUMBER":        true,
+	"BASE10NUM":     true,
+	"BASE16NUM":     true,
+	"POSINT":        true,
+	"NONNEGINT":     true,
+	"WORD":          true,
+	"UNICODEWORD":   true,
+	"UNICODENUMBER": true,
+}
+
+// LintWarning describes a problem found by LintPatterns in a pattern definition
+type LintWarning struct {
+	Pattern string
+	Message string
+}
+
+// String returns the warning message prefixed by the pattern name
+func (w LintWarning) String() string {
+	return w.Pattern + ": " + w.Message
+}
+
+// LintPatterns statically checks a map of pattern definitions and reports
+// patterns referencing missing sub-patterns, patterns chaining greedy
+// sequences, numeric or word patterns referenced one right after the other
+// and patterns defining the same capture name several times once composed.
+// References are resolved against m, then against the default patterns.
+// Warnings are sorted by pattern name
+func LintPatterns(m map[string]string) []LintWarning {
+	lookup := func(name string) (string, bool) {
+		if def, ok := m[name]; ok {
+			return def, true
+		}
+		def, ok := patterns[name]
+		return def, ok
+	}
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	warnings := []LintWarning{}
+	for _, name := range names {
+		def := m[name]
+		warn := func(format string, a ...interface{}) {
+			warnings = append(warnings, LintWarning{Pattern: name, Message: fmt.Sprintf(format, a...)})
+		}
+
+		reported := map[string]bool{}
+		for _, ref := range normalPattern.FindAllStringSubmatch(def, -1) {
+			if !validPattern.MatchString(ref[1]) {
+				warn("invalid reference `%%{%s}`", ref[1])
+				continue
+			}
+			syntax := strings.Split(ref[1], ":")[0]
+			if _, ok := lookup(syntax); !ok && !reported[syntax] {
+				reported[syntax] = true
+				warn("references missing pattern %%{%s}", syntax)
+			}
+		}
+
+		for _, loc := range greedyPattern.FindAllStringIndex(def, -1) {
+			if loc[0] > 0 && def[loc[0]-1] == '\\' {
+				continue
+			}
+			warn("greedy sequence `%s` leaves nothing to match for the second part", def[loc[0]:loc[1]])
+		}
+
+		refs := normalPattern.FindAllStringSubmatchIndex(def, -1)
+		for i := 1; i < len(refs); i++ {
+			prev, cur := refs[i-1], refs[i]
+			if prev[1] != cur[0] {
+				continue
+			}
+			prevName := strings.Split(def[prev[2]:prev[3]], ":")[0]
+			curName := strings.Split(def[cur[2]:cur[3]], ":")[0]
+			if adjacentPatterns[prevName] && adjacentPatterns[curName] {
+				warn("`%s` at offset %d directly follows `%s` with no separator, the split between them is ambiguous",
+					def[cur[0]:cur[1]], cur[0], def[prev[0]:prev[1]])
+			}
+		}
+
+		// Names shared by the branches of an alternation do not collide,
+		// every shared name is reported if the expression does not parse
+		expr := composeCaptures(def, lookup, map[string]bool{name: true})
+		counts := map[string]int{}
+		for _, capture := range inlinePattern.FindAllStringSubmatch(expr, -1) {
+			counts[capture[1]]++
+		}
+		collisions, err := duplicateCaptures(expr)
+		if err != nil {
+			collisions = collisions[:0]
+			for capture, n := range counts {
+				if n > 1 {
+					collisions = append(collisions, capture)
+				}
+			}
+		}
+		sort.Strings(collisions)
+		for _, capture := range collisions {
+			warn("capture %q is defined %d times once composed", capture, counts[capture])
+		}
+	}
+
+	return warnings
+}
+
+// composeCaptures returns the expression of a pattern definition with its
+// references replaced by the definitions of the patterns they reference, the
+// aliased ones as named captures. Missing patterns and patterns in visiting
+// are replaced by an empty group, so circular references are ignored
+func composeCaptures(def string, lookup func(string) (string, bool), visiting map[string]bool) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range normalPattern.FindAllStringSubmatchIndex(def, -1) {
+		b.WriteString(def[last:loc[0]])
+		last = loc[1]
+		ref := def[loc[2]:loc[3]]
+		if !validPattern.MatchString(ref) {
+			b.WriteString("(?:)")
+			continue
+		}
+		names := strings.Split(ref, ":")
+		if len(names) > 2 && names[2] == GTypeDrop {
+			names = names[:1]
+		}
+		sub := "(?:)"
+		if s, ok := lookup(names[0]); ok && !visiting[names[0]] {
+			visiting[names[0]] = true
+			sub = composeCaptures(s, lookup, visiting)
+			delete(visiting, names[0])
+		}
+		if len(names) > 1 {
+			b.WriteString("(?P<" + symbolicPattern.ReplaceAllString(names[1], "_") + ">" + sub + ")")
+		} else {
+			b.WriteString("(" + sub + ")")
+		}
+	}
+	b.WriteString(def[last:])
+	return b.String()
+}
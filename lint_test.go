@@ -0,0 +1,72 @@
+package grok
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLintPatterns(t *testing.T) {
+	warnings := LintPatterns(map[string]string{
+		"CLEAN":     `%{IP:client} %{NUMBER:port}`,
+		"MISSING":   `%{IP:client} %{NOPE} %{NOPE:again}`,
+		"GREEDY":    `%{GREEDYDATA:a}%{GREEDYDATA:b} .*.+`,
+		"ESCAPED":   `\.*%{WORD}`,
+		"COLLIDE":   `%{SYSLOGPROG} %{SYSLOGPROG}`,
+		"INLINE":    `(?P<client>\S+) %{IP:client}`,
+		"BRANCHES":  `%{IPV4:ip}|%{IPV6:ip} %{WORD:w:drop} %{WORD:w}`,
+		"RECURSIVE": `a%{RECURSIVE}?`,
+		"USESLOCAL": `%{CLEAN} %{WORD:verb}`,
+		"ADJACENT":  `v%{NUMBER:a}%{NUMBER:b} %{WORD:w}%{INT}`,
+		"SEPARATED": `%{NUMBER:a}.%{NUMBER:b} %{IP:ip}%{WORD:w}`,
+	})
+
+	got := map[string][]string{}
+	for _, w := range warnings {
+		got[w.Pattern] = append(got[w.Pattern], w.Message)
+	}
+
+	expected := map[string][]string{
+		"MISSING": {"references missing pattern %{NOPE}"},
+		"GREEDY": {
+			"greedy sequence `%{GREEDYDATA:a}%{GREEDYDATA:b}` leaves nothing to match for the second part",
+			"greedy sequence `.*.+` leaves nothing to match for the second part",
+		},
+		"COLLIDE": {
+			`capture "pid" is defined 2 times once composed`,
+			`capture "program" is defined 2 times once composed`,
+		},
+		"INLINE": {`capture "client" is defined 2 times once composed`},
+		"ADJACENT": {
+			"`%{NUMBER:b}` at offset 12 directly follows `%{NUMBER:a}` with no separator, the split between them is ambiguous",
+			"`%{INT}` at offset 33 directly follows `%{WORD:w}` with no separator, the split between them is ambiguous",
+		},
+	}
+
+	for name, msgs := range expected {
+		if strings.Join(got[name], "\n") != strings.Join(msgs, "\n") {
+			t.Errorf("%s: got %q, want %q", name, got[name], msgs)
+		}
+	}
+	for _, name := range []string{"CLEAN", "ESCAPED", "RECURSIVE", "USESLOCAL", "SEPARATED", "BRANCHES"} {
+		if len(got[name]) != 0 {
+			t.Errorf("%s: unexpected warnings %q", name, got[name])
+		}
+	}
+
+	for i := 1; i < len(warnings); i++ {
+		if warnings[i-1].Pattern > warnings[i].Pattern {
+			t.Fatalf("warnings are not sorted by pattern: %v", warnings)
+		}
+	}
+}
+
+func TestLintPatternsDefaults(t *testing.T) {
+	for _, w := range LintPatterns(CopyDefalutPatterns()) {
+		if strings.HasPrefix(w.Message, "references missing pattern") {
+			t.Errorf("default patterns should not reference missing patterns: %s", w)
+		}
+		if strings.HasSuffix(w.Message, "once composed") {
+			t.Errorf("default patterns should not define a capture twice: %s", w)
+		}
+	}
+}
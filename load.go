@@ -0,0 +1,98 @@
+package grok
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// includeDirective starts a line including the patterns of another file
+const includeDirective = "@include"
+
+// LoadPatternsFromReader reads pattern definitions from r, one
+// "NAME definition" per line. Empty lines and lines starting with '#' are
+// skipped. A line "@include file" adds the definitions of another pattern
+// file, a relative path being resolved against baseDir for r and against the
+// directory of the including file for nested includes. A definition overrides
+// any earlier one of the same name, including those of included files. An
+// include cycle is reported as an error
+func LoadPatternsFromReader(r io.Reader, baseDir string) (map[string]string, error) {
+	m := map[string]string{}
+	l := &patternLoader{patterns: m}
+	if err := l.load(r, "", baseDir); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// patternLoader accumulates the definitions read from a file and its includes
+type patternLoader struct {
+	patterns map[string]string
+	files    []string // files being read, for cycle detection
+}
+
+// load reads the definitions from r, name is the file r reads from or "" for
+// the reader given to LoadPatternsFromReader
+func (l *patternLoader) load(r io.Reader, name, dir string) error {
+	source := name
+	if source == "" {
+		source = "input"
+	}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if strings.HasPrefix(line, includeDirective+" ") {
+			file := strings.TrimSpace(line[len(includeDirective):])
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(dir, file)
+			}
+			if err := l.include(file); err != nil {
+				return fmt.Errorf("%s:%d: %w", source, n, err)
+			}
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || strings.TrimSpace(fields[1]) == "" {
+			return fmt.Errorf("%s:%d: missing definition for pattern %s", source, n, fields[0])
+		}
+		l.patterns[fields[0]] = strings.TrimSpace(fields[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	return nil
+}
+
+// include loads the definitions of file unless it is already being read
+func (l *patternLoader) include(file string) error {
+	file = filepath.Clean(file)
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	for i, f := range l.files {
+		if f == file {
+			return fmt.Errorf("include cycle: %s -> %s", strings.Join(l.files[i:], " -> "), file)
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	l.files = append(l.files, file)
+	defer func() {
+		l.files = l.files[:len(l.files)-1]
+	}()
+	return l.load(f, file, filepath.Dir(file))
+}
@@ -0,0 +1,114 @@
+package grok
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePatternFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "grok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadPatternsFromReader(t *testing.T) {
+	dir := writePatternFiles(t, map[string]string{
+		"common.grok":    "# common patterns\nWORDS \\w+( \\w+)*\n@include net/ip.grok\nPORT \\d+\n",
+		"net/ip.grok":    "IPADDR %{IPV4}\n@include ../shared.grok\n",
+		"shared.grok":    "SHARED shared\n",
+		"cycle/a.grok":   "A a\n@include b.grok\n",
+		"cycle/b.grok":   "B b\n@include ./a.grok\n",
+		"broken.grok":    "GOOD good\nBAD\n",
+		"self/self.grok": "@include self.grok\n",
+	})
+
+	m, err := LoadPatternsFromReader(strings.NewReader("@include common.grok\n\nPORT [1-9]\\d*\nSERVICE %{IPADDR}:%{PORT}\n"), dir)
+	if err != nil {
+		t.Fatalf("LoadPatternsFromReader failed: %v", err)
+	}
+	expected := map[string]string{
+		"WORDS":   `\w+( \w+)*`,
+		"IPADDR":  `%{IPV4}`,
+		"SHARED":  `shared`,
+		"PORT":    `[1-9]\d*`,
+		"SERVICE": `%{IPADDR}:%{PORT}`,
+	}
+	if len(m) != len(expected) {
+		t.Errorf("expected %d patterns, got %v", len(expected), m)
+	}
+	for name, def := range expected {
+		if m[name] != def {
+			t.Errorf("%s: expected %q, got %q", name, def, m[name])
+		}
+	}
+
+	errTests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"cycle", "@include cycle/a.grok\n", []string{"include cycle:", "a.grok -> ", "b.grok -> ", "a.grok"}},
+		{"self include", "@include self/self.grok\n", []string{"include cycle:", "self.grok -> ", "self.grok"}},
+		{"missing file", "@include nope.grok\n", []string{"input:1:", "nope.grok"}},
+		{"missing definition", "X x\n@include broken.grok\n", []string{"input:2:", "broken.grok:2: missing definition for pattern BAD"}},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadPatternsFromReader(strings.NewReader(tt.input), dir)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, s := range tt.expected {
+				if !strings.Contains(err.Error(), s) {
+					t.Errorf("expected error to contain %q, got %v", s, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadPatternsFromReaderCompile(t *testing.T) {
+	dir := writePatternFiles(t, map[string]string{
+		"app.grok": "APPID [a-z]+-\\d+\n",
+	})
+
+	m, err := LoadPatternsFromReader(strings.NewReader("@include app.grok\nAPPLOG %{APPID:id} %{INT:code:int}\n"), dir)
+	if err != nil {
+		t.Fatalf("LoadPatternsFromReader failed: %v", err)
+	}
+
+	patterns := CopyDefalutPatterns()
+	for name, def := range m {
+		patterns[name] = def
+	}
+	denormalized, _ := DenormalizePatternsFromMap(patterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern("%{APPLOG}", storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	values, err := gr.RunWithTypeInfo("web-42 7", false)
+	if err != nil {
+		t.Fatalf("RunWithTypeInfo failed: %v", err)
+	}
+	if values[0] != "web-42" || values[1] != int64(7) {
+		t.Errorf("unexpected values %v", values)
+	}
+}
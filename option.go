@@ -0,0 +1,425 @@
+package grok
+
+import (
+	"bufio"
+	"regexp"
+	"time"
+)
+
+// CompileOption configures the behavior of a compiled GrokRegexp
+type CompileOption func(*compileOptions)
+
+// compileOptions holds the settings applied by CompileOption values
+type compileOptions struct {
+	decimalComma bool
+	thousandsSep rune
+	dashAsEmpty  bool
+	dashFields   map[string]bool
+
+	maxDenormalizedLen int
+	maxInputLen        int
+	maxRepeat          int
+	trimLineEndings    bool
+	collapseWhitespace bool
+	anchorStart        bool
+	anchorEnd          bool
+	fullMatchField     string
+	conversionCache    int
+	location           *time.Location
+	keepRaw            map[string]bool
+
+	observer CompileObserver
+	engine   RegexEngine
+}
+
+// newCompileOptions applies opts over the default settings
+func newCompileOptions(opts []CompileOption) compileOptions {
+	var o compileOptions
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+	return o
+}
+
+// WithDecimalComma makes int and float conversions treat a comma as the
+// decimal separator, so "3,14" converts to 3.14
+func WithDecimalComma() CompileOption {
+	return func(o *compileOptions) {
+		o.decimalComma = true
+	}
+}
+
+// WithThousandsSeparator makes int and float conversions remove sep from the
+// value before parsing it, so "1,234,567" converts to 1234567 with a comma
+// separator. The separator is ignored when it is also the decimal separator,
+// a comma with WithDecimalComma or a dot without it
+func WithThousandsSeparator(sep rune) CompileOption {
+	return func(o *compileOptions) {
+		o.thousandsSep = sep
+	}
+}
+
+// WithDashAsEmpty makes the Run methods return an empty value instead of the
+// "-" placeholder used by many log formats for absent values. The conversion
+// applies to the given fields, or to every field when none is given
+func WithDashAsEmpty(fields ...string) CompileOption {
+	return func(o *compileOptions) {
+		if len(fields) == 0 {
+			o.dashAsEmpty = true
+			return
+		}
+		if o.dashFields == nil {
+			o.dashFields = map[string]bool{}
+		}
+		for _, f := range fields {
+			o.dashFields[f] = true
+		}
+	}
+}
+
+// isDashAsEmpty reports whether a "-" captured for the field is returned empty
+func (o *compileOptions) isDashAsEmpty(field string) bool {
+	return o.dashAsEmpty || o.dashFields[field]
+}
+
+// WithKeepRaw makes the typed outputs, such as RunWithTypeInfo,
+// RunMapWithTypeInfo and RunJSON, return the captured string of the given
+// fields instead of the converted value. The value is still converted to
+// check it, a value that cannot be converted being reported as usual. This
+// keeps the leading zeros of identifiers such as "007" declared int, at the
+// cost of returning a string that the caller must convert to use it as a
+// number. RunDetailed returns both forms
+func WithKeepRaw(fields ...string) CompileOption {
+	return func(o *compileOptions) {
+		if o.keepRaw == nil {
+			o.keepRaw = map[string]bool{}
+		}
+		for _, f := range fields {
+			o.keepRaw[f] = true
+		}
+	}
+}
+
+// WithMaxDenormalizedLen makes compilation fail with ErrPatternTooLarge when
+// the denormalized regular expression is longer than n bytes, before the
+// regular expression is compiled
+func WithMaxDenormalizedLen(n int) CompileOption {
+	return func(o *compileOptions) {
+		o.maxDenormalizedLen = n
+	}
+}
+
+// WithMaxRepeat makes compilation fail with ErrPatternTooLarge, before the
+// regular expression is compiled, when a bounded repetition such as {1,500}
+// repeats its expression more than n times. Nested repetitions multiply, so
+// (?:\d{1,10}){1,50} repeats \d up to 500 times. Each repetition adds a copy
+// of its expression to the compiled program, so this bounds the memory used
+// by patterns accepted from users. Unbounded repetitions such as + and * are
+// not limited. The default of 0 only applies the limit of the regexp package,
+// which rejects repetitions of more than 1000 copies
+func WithMaxRepeat(n int) CompileOption {
+	return func(o *compileOptions) {
+		o.maxRepeat = n
+	}
+}
+
+// WithMaxInputLen makes the Run methods fail with ErrInputTooLarge, without
+// matching, when the content is longer than n bytes. This bounds the time
+// spent on a single oversized line. The default of 0 sets no limit
+func WithMaxInputLen(n int) CompileOption {
+	return func(o *compileOptions) {
+		o.maxInputLen = n
+	}
+}
+
+// WithTrimLineEndings makes the GrokRegexp ignore the "\r" and "\n"
+// characters at the end of the content before matching, so that anchored
+// patterns match lines read with their CRLF or LF terminator. It is applied
+// independently of trimSpace
+func WithTrimLineEndings() CompileOption {
+	return func(o *compileOptions) {
+		o.trimLineEndings = true
+	}
+}
+
+// WithCollapseWhitespace makes the GrokRegexp replace every run of whitespace
+// in the content by a single space before matching, so that a pattern with
+// single spaces matches lines whose columns are aligned with several spaces or
+// tabs. The values are taken from the collapsed content, whose offsets differ
+// from those of the original content: the positions returned by
+// RunAllWithPositions and the remainder of RunWithRemainder refer to the
+// collapsed content, so the option should not be used along with them
+func WithCollapseWhitespace() CompileOption {
+	return func(o *compileOptions) {
+		o.collapseWhitespace = true
+	}
+}
+
+// WithAnchorStart makes the pattern match only at the start of the content,
+// as if it began with \A. The part of the content following the match is
+// still available from RunWithRemainder
+func WithAnchorStart() CompileOption {
+	return func(o *compileOptions) {
+		o.anchorStart = true
+	}
+}
+
+// WithAnchorEnd makes the pattern match only at the end of the content, as if
+// it ended with \z. It can be combined with WithAnchorStart to match the whole
+// content
+func WithAnchorEnd() CompileOption {
+	return func(o *compileOptions) {
+		o.anchorEnd = true
+	}
+}
+
+// WithFullMatchField adds a field with the given name holding the whole text
+// matched by the pattern. The field comes after the fields of the pattern in
+// MatchNames and in the results of the Run methods
+func WithFullMatchField(name string) CompileOption {
+	return func(o *compileOptions) {
+		o.fullMatchField = name
+	}
+}
+
+// WithRegexEngine makes the denormalized regular expression be compiled by
+// engine instead of the regexp package. Constructs the regexp package does
+// not support, such as backreferences, are then left to the engine
+func WithRegexEngine(engine RegexEngine) CompileOption {
+	return func(o *compileOptions) {
+		o.engine = engine
+	}
+}
+
+// regexEngine returns the engine compiling the regular expressions
+func (o *compileOptions) regexEngine() RegexEngine {
+	if o.engine == nil {
+		return stdEngine{}
+	}
+	return o.engine
+}
+
+// WithConversionCache makes the typed outputs remember the conversion of the
+// size most recently seen values of each typed field, which saves parsing the
+// values again for fields with few distinct values such as status codes.
+// Values of json and mac fields are not cached since the converted values are
+// mutable
+func WithConversionCache(size int) CompileOption {
+	return func(o *compileOptions) {
+		o.conversionCache = size
+	}
+}
+
+// WithDefaultLocation makes the timestamp type and Scan interpret timestamps
+// without zone in loc instead of UTC. Such timestamps are returned in UTC,
+// while timestamps with an explicit zone or offset keep it
+func WithDefaultLocation(loc *time.Location) CompileOption {
+	return func(o *compileOptions) {
+		o.location = loc
+	}
+}
+
+// CompileObserver receives the duration of the steps of a compilation, e.g.
+// to record them as metrics. Both methods are given the grok pattern being
+// compiled. OnCompile is not called when denormalization fails
+type CompileObserver interface {
+	OnDenormalize(pattern string, dur time.Duration)
+	OnCompile(pattern string, dur time.Duration, err error)
+}
+
+// WithCompileObserver makes compilation report to obs the time spent
+// denormalizing the pattern and compiling the regular expression
+func WithCompileObserver(obs CompileObserver) CompileOption {
+	return func(o *compileOptions) {
+		o.observer = obs
+	}
+}
+
+// DenormalizeOption configures how patterns are denormalized
+type DenormalizeOption func(*denormalizeOptions)
+
+// denormalizeOptions holds the settings applied by DenormalizeOption values
+type denormalizeOptions struct {
+	expandDepth int
+	strictTypes bool
+	refPattern  *regexp.Regexp
+	refOpen     string
+	refClose    string
+	sanitize    func(string) string
+	ignoreTypes bool
+	wordBounds  bool
+
+	// redundantTypes reports annotations that leave values unchanged
+	redundantTypes bool
+
+	foreignSyntax bool
+}
+
+// newDenormalizeOptions applies opts over the default settings
+func newDenormalizeOptions(opts []DenormalizeOption) denormalizeOptions {
+	var o denormalizeOptions
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+	return o
+}
+
+// WithExpandDepth lets a pattern that references itself, directly or through
+// other patterns, be expanded up to n levels deep. Past that depth the
+// reference is replaced by an expression that never matches. The default of 0
+// rejects any circular dependency
+func WithExpandDepth(n int) DenormalizeOption {
+	return func(o *denormalizeOptions) {
+		o.expandDepth = n
+	}
+}
+
+// WithStrictTypes checks type annotations against the pattern they apply to
+// and records a warning, available from GrokPattern.Warnings, when the
+// pattern can never produce a valid value of the declared type
+func WithStrictTypes() DenormalizeOption {
+	return func(o *denormalizeOptions) {
+		o.strictTypes = true
+	}
+}
+
+// WithDelimiters makes pattern references use the given opening and closing
+// strings instead of "%{" and "}", e.g. WithDelimiters("<<", ">>") to write
+// <<IP:client>>
+func WithDelimiters(open, close string) DenormalizeOption {
+	re := regexp.MustCompile(regexp.QuoteMeta(open) + `([\w-.]+(?::[\w-.]+(?::[\w-.]+)?)?)` + regexp.QuoteMeta(close))
+	return WithReferencePattern(re, open, close)
+}
+
+// WithReferencePattern makes pattern references be found with re, whose first
+// capturing group must hold the NAME[:alias[:type]] reference. The open and
+// close strings are the delimiters around that group, used in error messages
+func WithReferencePattern(re *regexp.Regexp, open, close string) DenormalizeOption {
+	return func(o *denormalizeOptions) {
+		o.refPattern = re
+		o.refOpen = open
+		o.refClose = close
+	}
+}
+
+// WithNameSanitizer makes the alias of a reference be transformed by fn
+// instead of having every non-word character replaced by an underscore. The
+// returned name is used as is for the capture group, so it must be a valid
+// capture name for the regexp package: letters, digits and underscores only
+func WithNameSanitizer(fn func(string) string) DenormalizeOption {
+	return func(o *denormalizeOptions) {
+		o.sanitize = fn
+	}
+}
+
+// sanitizeName returns the capture name used for an alias
+func (o *denormalizeOptions) sanitizeName(alias string) string {
+	if o.sanitize == nil {
+		return symbolicPattern.ReplaceAllString(alias, "_")
+	}
+	return o.sanitize(alias)
+}
+
+// WithIgnoreTypes makes denormalization ignore the type part of references,
+// so %{WORD:name:anything} denormalizes like %{WORD:name} instead of failing
+// on an unknown type. The resulting pattern declares no type of its own, e.g.
+// for tools that only need the regular expression and the field names
+func WithIgnoreTypes() DenormalizeOption {
+	return func(o *denormalizeOptions) {
+		o.ignoreTypes = true
+	}
+}
+
+// WithWordBoundaries makes the named captures match whole words: \b is added
+// before a capture whose values always start with a word character, and after
+// one whose values always end with a word character, so %{POSINT:n} no longer
+// captures "123" from "id123". A capture whose values may start or end with
+// another character is left open on that side, e.g. %{INT:n} still captures
+// "123" from "abc123" since its values may start with a sign. A word character
+// written right next to a capture in the pattern prevents it from matching,
+// e.g. %{INT:n}ms never matches "10ms", unnamed references such as %{INT}ms
+// are left unchanged
+func WithWordBoundaries() DenormalizeOption {
+	return func(o *denormalizeOptions) {
+		o.wordBounds = true
+	}
+}
+
+// WithForeignSyntax accepts definitions using constructs the regexp package
+// does not support, such as lookarounds or backreferences, instead of
+// failing with ErrUnsupportedSyntax. It is meant for patterns compiled with
+// a RegexEngine supporting them, set by WithRegexEngine: the patterns of a
+// storage denormalized with it are referenced as usual, while compiling them
+// with the regexp package fails
+func WithForeignSyntax() DenormalizeOption {
+	return func(o *denormalizeOptions) {
+		o.foreignSyntax = true
+	}
+}
+
+// validReference reports whether the NAME[:alias[:type]] body of a reference
+// is well formed
+func (o *denormalizeOptions) validReference(ref string) bool {
+	if o.ignoreTypes {
+		return untypedPattern.MatchString(ref)
+	}
+	return validPattern.MatchString(ref)
+}
+
+// reference returns the regular expression matching pattern references
+func (o *denormalizeOptions) reference() *regexp.Regexp {
+	if o.refPattern == nil {
+		return normalPattern
+	}
+	return o.refPattern
+}
+
+// formatReference formats a reference body with the configured delimiters
+func (o *denormalizeOptions) formatReference(ref string) string {
+	if o.refPattern == nil {
+		return "%{" + ref + "}"
+	}
+	return o.refOpen + ref + o.refClose
+}
+
+// ReaderOption configures how GrokSet.RunReader reads its input
+type ReaderOption func(*readerOptions)
+
+// readerOptions holds the settings applied by ReaderOption values
+type readerOptions struct {
+	bufferSize int
+	split      bufio.SplitFunc
+}
+
+// newReaderOptions applies opts over the default settings
+func newReaderOptions(opts []ReaderOption) readerOptions {
+	var o readerOptions
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+	return o
+}
+
+// WithBufferSize sets the size of the longest line RunReader can read, 64KiB
+// by default. Reading fails with bufio.ErrTooLong on a longer line, or longer
+// record when WithSplitter is used
+func WithBufferSize(size int) ReaderOption {
+	return func(o *readerOptions) {
+		o.bufferSize = size
+	}
+}
+
+// WithSplitter makes RunReader split its input into records with split
+// instead of lines, e.g. for records terminated by NUL bytes or framed by a
+// binary header. Each token returned by split is matched as a record
+func WithSplitter(split bufio.SplitFunc) ReaderOption {
+	return func(o *readerOptions) {
+		o.split = split
+	}
+}
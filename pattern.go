@@ -2,31 +2,63 @@ package grok
 
 import (
 	"bytes"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	pathpkg "path"
 	"regexp"
+	resyntax "regexp/syntax"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/spf13/cast"
 )
 
 const (
-	GTypeStr    = "str"
-	GTypeString = "string"
-	GTypeInt    = "int"
-	GTypeFloat  = "float"
-	GTypeBool   = "bool"
+	GTypeStr       = "str"
+	GTypeString    = "string"
+	GTypeInt       = "int"
+	GTypeFloat     = "float"
+	GTypeBool      = "bool"
+	GTypeDuration  = "duration"
+	GTypeJSON      = "json"
+	GTypeMAC       = "mac"
+	GTypeTimestamp = "timestamp"
+	GTypeDrop      = "drop" // matched but left out of the fields, e.g. %{WORD:_:drop}
 )
 
 var (
-	validPattern    = regexp.MustCompile(`^\w+([-.]\w+)*(:([-.\w]+)(:(string|str|float|int|bool))?)?$`)
+	validPattern    = regexp.MustCompile(`^\w+([-.]\w+)*(:([-.\w]+)(:(string|str|float|int|bool|duration|json|mac|timestamp|drop))?)?$`)
+	untypedPattern  = regexp.MustCompile(`^\w+([-.]\w+)*(:([-.\w]+)(:[-.\w]+)?)?$`)
 	normalPattern   = regexp.MustCompile(`%{([\w-.]+(?::[\w-.]+(?::[\w-.]+)?)?)}`)
 	symbolicPattern = regexp.MustCompile(`\W`)
+	jsonInteger     = regexp.MustCompile(`^-?(?:0|[1-9][0-9]*)$`)
+	jsonNumber      = regexp.MustCompile(`^-?(?:0|[1-9][0-9]*)(?:\.[0-9]+)?(?:[eE][+-]?[0-9]+)?$`)
 )
 
 var (
-	ErrNotCompiled = errors.New("not compiled")
-	ErrMismatch    = errors.New("mismatch")
+	ErrNotCompiled     = errors.New("not compiled")
+	ErrMismatch        = errors.New("mismatch")
+	ErrPatternTooLarge = errors.New("denormalized pattern too large")
+	ErrFieldConstraint = errors.New("field constraint not satisfied")
+	// ErrDuplicateCaptureName reports a capture name that can be captured
+	// more than once by the same match. The regexp package itself accepts
+	// duplicate names, earlier versions compiled such patterns and reported
+	// the value of the last group of the name
+	ErrDuplicateCaptureName = errors.New("duplicate capture name")
+	ErrConversion           = errors.New("conversion failed")
+	ErrUnknownDiscriminator = errors.New("no pattern registered for discriminator")
+	ErrUnsupportedSyntax    = errors.New("unsupported regular expression syntax")
+	ErrInputTooLarge        = errors.New("input too large")
+	ErrUnknownType          = errors.New("no converter for type")
 )
 
 // GrokPattern represents a grok pattern with its denormalized regular expression
@@ -34,6 +66,23 @@ type GrokPattern struct {
 	pattern      string
 	denormalized string
 	varbType     map[string]string
+	fieldSyntax  map[string]string
+	captures     []string // distinct names of the capture groups of denormalized
+	warnings     []Warning
+}
+
+// Warning describes a non fatal issue found in a pattern
+type Warning struct {
+	Field   string
+	Message string
+}
+
+// String returns the warning message prefixed by the field it concerns
+func (w Warning) String() string {
+	if w.Field == "" {
+		return w.Message
+	}
+	return w.Field + ": " + w.Message
 }
 
 // Pattern returns the original pattern string
@@ -55,15 +104,324 @@ func (g *GrokPattern) TypedVar() map[string]string {
 	return ret
 }
 
+// FieldSyntax returns the name of the pattern each named field was captured
+// with, e.g. "IP" for %{IP:server}. Fields of referenced patterns are
+// included, capture groups written inline have no entry
+func (g *GrokPattern) FieldSyntax() map[string]string {
+	ret := map[string]string{}
+	for k, v := range g.fieldSyntax {
+		ret[k] = v
+	}
+	return ret
+}
+
+// String returns a deterministic description of the pattern for debugging
+// and golden tests: the original pattern, the denormalized regular expression
+// and the declared types sorted by field name, one per line
+func (g *GrokPattern) String() string {
+	var b strings.Builder
+	b.WriteString("pattern: ")
+	b.WriteString(g.pattern)
+	b.WriteString("\ndenormalized: ")
+	b.WriteString(g.denormalized)
+	b.WriteString("\ntypes:")
+
+	names := make([]string, 0, len(g.varbType))
+	for name := range g.varbType {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString("\n  ")
+		b.WriteString(name)
+		b.WriteString(": ")
+		b.WriteString(g.varbType[name])
+	}
+	return b.String()
+}
+
+// PrettyDenormalized returns the denormalized regular expression laid out
+// for reading: every named group starts on its own line and its content is
+// indented, unnamed groups are kept inline. Non printable characters are
+// written as \x{...} escapes so the result is safe to display in a terminal.
+// Whitespace is added to the expression, so the result is meant for display
+// only and must not be compiled
+func (g *GrokPattern) PrettyDenormalized() string {
+	var b strings.Builder
+	expr := g.denormalized
+	depth := 0
+	pending := false
+	named := []bool{}
+	inClass := false
+
+	write := func(text string) {
+		if pending && b.Len() > 0 {
+			b.WriteByte('\n')
+			b.WriteString(strings.Repeat("  ", depth))
+		}
+		pending = false
+		for _, r := range text {
+			if unicode.IsPrint(r) {
+				b.WriteRune(r)
+			} else {
+				fmt.Fprintf(&b, `\x{%x}`, r)
+			}
+		}
+	}
+
+	for i := 0; i < len(expr); {
+		r, size := utf8.DecodeRuneInString(expr[i:])
+		switch {
+		case r == '\\' && i+size < len(expr):
+			_, next := utf8.DecodeRuneInString(expr[i+size:])
+			size += next
+			write(expr[i : i+size])
+		case inClass:
+			inClass = r != ']'
+			write(expr[i : i+size])
+		case r == '[':
+			inClass = true
+			// A closing bracket right after the opening one is a literal
+			if strings.HasPrefix(expr[i:], "[^]") {
+				size = 3
+			} else if strings.HasPrefix(expr[i:], "[]") {
+				size = 2
+			}
+			write(expr[i : i+size])
+		case r == '(' && (strings.HasPrefix(expr[i:], "(?P<") || strings.HasPrefix(expr[i:], "(?<")):
+			size = strings.IndexByte(expr[i:], '>') + 1
+			pending = true
+			write(expr[i : i+size])
+			named = append(named, true)
+			depth++
+			pending = true
+		case r == '(':
+			named = append(named, false)
+			write(expr[i : i+size])
+		case r == ')' && len(named) > 0 && named[len(named)-1]:
+			named = named[:len(named)-1]
+			depth--
+			pending = true
+			write(")")
+			pending = true
+		case r == ')' && len(named) > 0:
+			named = named[:len(named)-1]
+			write(")")
+		default:
+			write(expr[i : i+size])
+		}
+		i += size
+	}
+
+	return b.String()
+}
+
+// Warnings returns the warnings collected while denormalizing the pattern
+func (g *GrokPattern) Warnings() []Warning {
+	return append([]Warning(nil), g.warnings...)
+}
+
+// checkTypeAnnotation reports whether the referenced pattern can produce a
+// value of the declared type. It is a best-effort check: numeric types are
+// only flagged when the regular expression cannot match a single digit
+func checkTypeAnnotation(syntax, alias, varType string, gP *GrokPattern) (Warning, bool) {
+	switch varType {
+	case GTypeInt, GTypeFloat:
+		re, err := resyntax.Parse(gP.denormalized, resyntax.Perl)
+		if err != nil || canMatchDigit(re) {
+			return Warning{}, true
+		}
+		return Warning{
+			Field:   alias,
+			Message: fmt.Sprintf("type %s is applied to %%{%s} which can never match a number", varType, syntax),
+		}, false
+	}
+	return Warning{}, true
+}
+
+// canMatchDigit reports whether any part of re can match an ASCII digit
+func canMatchDigit(re *resyntax.Regexp) bool {
+	switch re.Op {
+	case resyntax.OpAnyChar, resyntax.OpAnyCharNotNL:
+		return true
+	case resyntax.OpLiteral:
+		for _, r := range re.Rune {
+			if r >= '0' && r <= '9' {
+				return true
+			}
+		}
+	case resyntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			if re.Rune[i] <= '9' && re.Rune[i+1] >= '0' {
+				return true
+			}
+		}
+	}
+	for _, sub := range re.Sub {
+		if canMatchDigit(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// wordEdges reports whether every non-empty string matched by expr starts,
+// respectively ends, with an ASCII word character, the characters around
+// which \b can be tested. Both are false when expr can match an empty string
+// or cannot be parsed
+func wordEdges(expr string) (start, end bool) {
+	re, err := resyntax.Parse(expr, resyntax.Perl)
+	if err != nil {
+		return false, false
+	}
+	start, nullable := wordEdge(re, false)
+	if nullable {
+		return false, false
+	}
+	end, _ = wordEdge(re, true)
+	return start, end
+}
+
+// wordEdge reports whether the first character, or the last one when
+// fromEnd is set, of every string matched by re is an ASCII word character,
+// and whether re can match an empty string
+func wordEdge(re *resyntax.Regexp, fromEnd bool) (word, nullable bool) {
+	switch re.Op {
+	case resyntax.OpEmptyMatch, resyntax.OpBeginLine, resyntax.OpEndLine,
+		resyntax.OpBeginText, resyntax.OpEndText, resyntax.OpWordBoundary,
+		resyntax.OpNoWordBoundary:
+		return true, true
+	case resyntax.OpNoMatch:
+		return true, false
+	case resyntax.OpLiteral:
+		if len(re.Rune) == 0 {
+			return true, true
+		}
+		r := re.Rune[0]
+		if fromEnd {
+			r = re.Rune[len(re.Rune)-1]
+		}
+		return isWordRune(r), false
+	case resyntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				if !isWordRune(r) {
+					return false, false
+				}
+			}
+		}
+		return true, false
+	case resyntax.OpCapture, resyntax.OpPlus:
+		return wordEdge(re.Sub[0], fromEnd)
+	case resyntax.OpStar, resyntax.OpQuest:
+		word, _ = wordEdge(re.Sub[0], fromEnd)
+		return word, true
+	case resyntax.OpRepeat:
+		if re.Max == 0 {
+			return true, true
+		}
+		word, nullable = wordEdge(re.Sub[0], fromEnd)
+		return word, nullable || re.Min == 0
+	case resyntax.OpConcat:
+		word = true
+		for i := range re.Sub {
+			sub := re.Sub[i]
+			if fromEnd {
+				sub = re.Sub[len(re.Sub)-1-i]
+			}
+			w, n := wordEdge(sub, fromEnd)
+			word = word && w
+			if !n {
+				return word, false
+			}
+		}
+		return word, true
+	case resyntax.OpAlternate:
+		word = true
+		for _, sub := range re.Sub {
+			w, n := wordEdge(sub, fromEnd)
+			word = word && w
+			nullable = nullable || n
+		}
+		return word, nullable
+	}
+	return false, false
+}
+
+// isWordRune reports whether r is matched by \w
+func isWordRune(r rune) bool {
+	return r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
 // PatternStorageIface defines the interface for pattern storage
 type PatternStorageIface interface {
 	GetPattern(string) (*GrokPattern, bool)
 	SetPattern(string, *GrokPattern)
 }
 
+// PatternNamer is an optional interface of a PatternStorageIface able to list
+// the names of its patterns
+type PatternNamer interface {
+	Names() []string
+}
+
 // PatternStorage is a slice-based implementation of PatternStorageIface
 type PatternStorage []map[string]*GrokPattern
 
+// NewPatternStorage returns a storage looking patterns up in defs in order.
+// Nil maps are skipped, and an empty map is added when no map is left so that
+// SetPattern always has a map to store patterns in
+func NewPatternStorage(defs ...map[string]*GrokPattern) PatternStorage {
+	p := make(PatternStorage, 0, len(defs))
+	for _, m := range defs {
+		if m != nil {
+			p = append(p, m)
+		}
+	}
+	if len(p) == 0 {
+		p = append(p, map[string]*GrokPattern{})
+	}
+	return p
+}
+
+// IsEmpty reports whether the storage holds no pattern
+func (p PatternStorage) IsEmpty() bool {
+	for _, v := range p {
+		if len(v) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// With returns a new storage looking patterns up in the maps of other before
+// those of p, so that the patterns of other shadow those of p with the same
+// name. The maps are shared, not copied. SetPattern on the returned storage
+// stores in its last map: the last map of p, or when p has no map the last
+// map of other, and nothing is stored when neither has a map
+func (p PatternStorage) With(other PatternStorage) PatternStorage {
+	ret := make(PatternStorage, 0, len(other)+len(p))
+	ret = append(ret, other...)
+	return append(ret, p...)
+}
+
+// Names returns the sorted names of the patterns of every map of the storage,
+// a name defined in several maps is listed once
+func (p PatternStorage) Names() []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, v := range p {
+		for name := range v {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
 // GetPattern retrieves a pattern from storage
 func (p PatternStorage) GetPattern(pattern string) (*GrokPattern, bool) {
 	for _, v := range p {
@@ -74,33 +432,250 @@ func (p PatternStorage) GetPattern(pattern string) (*GrokPattern, bool) {
 	return nil, false
 }
 
-// SetPattern stores a pattern in the last map of the storage
+// SetPattern stores a pattern in the last map of the storage, it does nothing
+// when the storage has no map, see NewPatternStorage
 func (p PatternStorage) SetPattern(patternAlias string, gp *GrokPattern) {
 	if len(p) > 0 {
 		p[len(p)-1][patternAlias] = gp
 	}
 }
 
+// Freeze returns an immutable copy of the storage, which can be shared by
+// goroutines without locking. Later changes to p do not affect the copy, and
+// calling SetPattern on the copy panics
+func (p PatternStorage) Freeze() PatternStorageIface {
+	frozen := frozenStorage{}
+	for i := len(p) - 1; i >= 0; i-- {
+		for name, gp := range p[i] {
+			frozen[name] = gp
+		}
+	}
+	return frozen
+}
+
+// frozenStorage is the read-only PatternStorageIface returned by Freeze
+type frozenStorage map[string]*GrokPattern
+
+// GetPattern retrieves a pattern from storage
+func (f frozenStorage) GetPattern(pattern string) (*GrokPattern, bool) {
+	gp, ok := f[pattern]
+	return gp, ok
+}
+
+// Names returns the sorted names of the patterns of the storage
+func (f frozenStorage) Names() []string {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetPattern panics, a frozen storage cannot be modified
+func (f frozenStorage) SetPattern(patternAlias string, gp *GrokPattern) {
+	panic(fmt.Sprintf("grok: SetPattern(%q) on a frozen pattern storage", patternAlias))
+}
+
+// Update replaces the definition of the named pattern and denormalizes it
+// again, along with every pattern of the storage that depends on it directly
+// or transitively. The other patterns are left untouched. It returns the
+// denormalized expression of each recomputed pattern keyed by name. If any of
+// them fails to denormalize the storage is not modified. A pattern that is not
+// in the storage yet is added with SetPattern. The patterns are denormalized
+// with opts, which should be those the storage was built with, so that the
+// references written with WithDelimiters are found as well
+func (p PatternStorage) Update(name, definition string, opts ...DenormalizeOption) (map[string]string, error) {
+	o := newDenormalizeOptions(opts)
+	reference := o.reference()
+
+	// Index the patterns depending on each pattern, the first map holding a
+	// name shadows the following ones as in GetPattern
+	owner := map[string]map[string]*GrokPattern{}
+	dependents := map[string][]string{}
+	for _, m := range p {
+		for n, gp := range m {
+			if _, ok := owner[n]; ok {
+				continue
+			}
+			owner[n] = m
+			for _, values := range reference.FindAllStringSubmatch(gp.pattern, -1) {
+				if len(values) < 2 || values[1] == "" {
+					continue
+				}
+				ref := strings.SplitN(values[1], ":", 2)[0]
+				dependents[ref] = append(dependents[ref], n)
+			}
+		}
+	}
+
+	defs := map[string]string{name: definition}
+	queue := []string{name}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, dep := range dependents[n] {
+			if _, ok := defs[dep]; !ok {
+				defs[dep] = owner[dep][dep].pattern
+				queue = append(queue, dep)
+			}
+		}
+	}
+
+	unaffected := map[string]*GrokPattern{}
+	for n, m := range owner {
+		if _, ok := defs[n]; !ok {
+			unaffected[n] = m[n]
+		}
+	}
+
+	valid, invalid := DenormalizePatternsFromMapWithOptions(defs, []map[string]*GrokPattern{unaffected}, opts...)
+	if len(invalid) != 0 {
+		return nil, invalidPatternsError(invalid)
+	}
+
+	ret := make(map[string]string, len(defs))
+	for n := range defs {
+		if m, ok := owner[n]; ok {
+			m[n] = valid[n]
+		} else {
+			p.SetPattern(n, valid[n])
+		}
+		ret[n] = valid[n].denormalized
+	}
+	return ret, nil
+}
+
+// MinimalStorage returns a storage holding only the patterns of full that the
+// grok patterns of inputs depend on, directly or transitively, e.g. to keep
+// in memory just the definitions an application uses. A pattern name is kept
+// by passing a reference to it such as "%{SYSLOGLINE}". It fails when a
+// reference cannot be found in full. References are found with the syntax
+// configured by opts, e.g. WithDelimiters, which should be the options full
+// was built with
+func MinimalStorage(inputs []string, full PatternStorageIface, opts ...DenormalizeOption) (PatternStorage, error) {
+	o := newDenormalizeOptions(opts)
+	reference := o.reference()
+
+	kept := map[string]*GrokPattern{}
+	var visit func(input string) error
+	visit = func(input string) error {
+		for _, m := range reference.FindAllStringSubmatch(input, -1) {
+			if len(m) < 2 || m[1] == "" {
+				continue
+			}
+			name := strings.SplitN(m[1], ":", 2)[0]
+			if _, ok := kept[name]; ok {
+				continue
+			}
+			var gp *GrokPattern
+			if full != nil {
+				gp, _ = full.GetPattern(name)
+			}
+			if gp == nil {
+				return fmt.Errorf("no pattern found for %s", o.formatReference(name))
+			}
+			kept[name] = gp
+			if err := visit(gp.pattern); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		return nil
+	}
+
+	for _, input := range inputs {
+		if err := visit(input); err != nil {
+			return nil, err
+		}
+	}
+	return PatternStorage{kept}, nil
+}
+
 // DenormalizePattern denormalizes a single pattern to its regular expression
+// A capture name defined twice, outside of the branches of an alternation,
+// fails with ErrDuplicateCaptureName giving the offsets of both definitions.
+// This is a change from earlier versions, which accepted such patterns, e.g.
+// %{WORD:a} %{WORD:a}, and kept the value of the last group
 func DenormalizePattern(input string, denormalized ...PatternStorageIface) (*GrokPattern, error) {
+	var storage PatternStorageIface
+	if len(denormalized) > 0 {
+		storage = denormalized[0]
+	}
+	return denormalizePattern(input, storage, newDenormalizeOptions(nil))
+}
+
+// DenormalizePatternWithOptions denormalizes a single pattern like
+// DenormalizePattern, configured by opts
+func DenormalizePatternWithOptions(input string, storage PatternStorageIface, opts ...DenormalizeOption) (*GrokPattern, error) {
+	return denormalizePattern(input, storage, newDenormalizeOptions(opts))
+}
+
+func denormalizePattern(input string, storage PatternStorageIface, opts denormalizeOptions) (*GrokPattern, error) {
+	return denormalizeReferences(input, opts.reference().FindAllStringSubmatchIndex(input, -1), storage, opts)
+}
+
+// denormalizeReferences denormalizes input given the locations of its
+// references, as returned by FindAllStringSubmatchIndex
+func denormalizeReferences(input string, refs [][]int, storage PatternStorageIface, opts denormalizeOptions) (*GrokPattern, error) {
+	if !opts.foreignSyntax {
+		if err := checkSyntax(input); err != nil {
+			return nil, fmt.Errorf("pattern `%s`: %w", input, err)
+		}
+	}
+
 	gPattern := &GrokPattern{
-		varbType: make(map[string]string),
-		pattern:  input,
+		varbType:    make(map[string]string),
+		fieldSyntax: make(map[string]string),
+		pattern:     input,
 	}
 
-	pattern := input
+	var buffer bytes.Buffer
+	last := 0
+
+	// Offset in input of the part defining each capture name, a name
+	// defined by two parts may be a duplicate
+	origins := map[string]int{}
+	var duplicate string
+	var offsets []int
+	addCapture := func(name string, offset int) {
+		first, ok := origins[name]
+		if !ok {
+			origins[name] = offset
+		} else if duplicate == "" {
+			duplicate = name
+			offsets = []int{first, offset}
+		}
+	}
+	addInline := func(segment string, offset int) {
+		for _, m := range inlinePattern.FindAllStringSubmatchIndex(segment, -1) {
+			addCapture(segment[m[2]:m[3]], offset+m[0])
+		}
+	}
 
-	for _, values := range normalPattern.FindAllStringSubmatch(pattern, -1) {
-		if !validPattern.MatchString(values[1]) {
-			return nil, fmt.Errorf("invalid pattern `%%{%s}`", values[1])
+	for _, loc := range refs {
+		// A custom reference pattern may match without its first group
+		if len(loc) < 4 || loc[2] < 0 {
+			return nil, fmt.Errorf("invalid pattern `%s`", input[loc[0]:loc[1]])
+		}
+		ref := input[loc[2]:loc[3]]
+		if !opts.validReference(ref) {
+			return nil, fmt.Errorf("invalid pattern `%s`", opts.formatReference(ref))
 		}
 
-		names := strings.Split(values[1], ":")
+		names := strings.Split(ref, ":")
 		syntax, alias := names[0], names[0]
+		if opts.ignoreTypes && len(names) > 2 {
+			names = names[:2]
+		}
+		// A dropped field is matched like a reference without alias
+		if len(names) > 2 && names[2] == GTypeDrop {
+			names = names[:1]
+		}
 
-		// Replace non-word characters with underscore for alias
+		// Replace non-word characters with underscore for alias, unless a
+		// sanitizer is configured
 		if len(names) > 1 {
-			alias = symbolicPattern.ReplaceAllString(names[1], "_")
+			alias = opts.sanitizeName(names[1])
 		}
 
 		// Get the data type of the variable, if any
@@ -114,90 +689,242 @@ func DenormalizePattern(input string, denormalized ...PatternStorageIface) (*Gro
 				gPattern.varbType[alias] = GTypeFloat
 			case GTypeBool:
 				gPattern.varbType[alias] = GTypeBool
+			case GTypeDuration:
+				gPattern.varbType[alias] = GTypeDuration
+			case GTypeJSON:
+				gPattern.varbType[alias] = GTypeJSON
+			case GTypeMAC:
+				gPattern.varbType[alias] = GTypeMAC
+			case GTypeTimestamp:
+				gPattern.varbType[alias] = GTypeTimestamp
 			default:
-				return nil, fmt.Errorf("pattern: `%%{%s}`: invalid varb data type: `%s`",
-					pattern, names[2])
+				return nil, fmt.Errorf("pattern: `%s`: invalid varb data type: `%s`",
+					opts.formatReference(ref), names[2])
 			}
 		}
 
-		if len(denormalized) == 0 {
-			return nil, fmt.Errorf("no pattern found for %%{%s}", syntax)
+		if storage == nil {
+			return nil, fmt.Errorf("no pattern found for %s", opts.formatReference(syntax))
 		}
 
-		gP, ok := denormalized[0].GetPattern(syntax)
-		if !ok {
-			return nil, fmt.Errorf("no pattern found for %%{%s}", syntax)
+		gP, ok := storage.GetPattern(syntax)
+		if !ok || gP == nil {
+			return nil, fmt.Errorf("no pattern found for %s", opts.formatReference(syntax))
+		}
+
+		if opts.strictTypes && len(names) > 2 {
+			if w, ok := checkTypeAnnotation(syntax, alias, gPattern.varbType[alias], gP); !ok {
+				gPattern.warnings = append(gPattern.warnings, w)
+			}
+		}
+		if opts.redundantTypes && len(names) > 2 && gPattern.varbType[alias] == GTypeStr {
+			gPattern.warnings = append(gPattern.warnings, Warning{
+				Field:   alias,
+				Message: fmt.Sprintf("type %s has no effect, captured values are strings", names[2]),
+			})
 		}
 
-		// Merge type information from the referenced pattern
+		// Merge type information from the referenced pattern, a field typed
+		// differently on both sides is ambiguous
 		for key, dtype := range gP.varbType {
-			if _, ok := gPattern.varbType[key]; !ok {
+			if cur, ok := gPattern.varbType[key]; !ok {
 				gPattern.varbType[key] = dtype
+			} else if cur != dtype && !opts.ignoreTypes {
+				return nil, fmt.Errorf("pattern: `%s`: conflicting data types for `%s`: `%s` and `%s` declared by `%s`",
+					opts.formatReference(ref), key, cur, dtype, syntax)
+			}
+		}
+		for key, s := range gP.fieldSyntax {
+			if _, ok := gPattern.fieldSyntax[key]; !ok {
+				gPattern.fieldSyntax[key] = s
 			}
 		}
+		if len(names) > 1 {
+			gPattern.fieldSyntax[alias] = syntax
+		}
+
+		addInline(input[last:loc[0]], last)
+		if len(names) > 1 {
+			addCapture(alias, loc[0])
+		}
+		for _, name := range gP.capturedNames() {
+			addCapture(name, loc[0])
+		}
 
-		var buffer bytes.Buffer
+		buffer.WriteString(input[last:loc[0]])
 		if len(names) > 1 {
+			var start, end bool
+			if opts.wordBounds {
+				start, end = wordEdges(gP.denormalized)
+			}
+			if start {
+				buffer.WriteString(`\b`)
+			}
 			buffer.WriteString("(?P<")
 			buffer.WriteString(alias)
 			buffer.WriteString(">")
 			buffer.WriteString(gP.denormalized)
 			buffer.WriteString(")")
+			if end {
+				buffer.WriteString(`\b`)
+			}
 		} else {
 			buffer.WriteString("(")
 			buffer.WriteString(gP.denormalized)
 			buffer.WriteString(")")
 		}
-		pattern = strings.ReplaceAll(pattern, values[0], buffer.String())
+		last = loc[1]
 	}
+	addInline(input[last:], last)
+	buffer.WriteString(input[last:])
 
-	gPattern.denormalized = pattern
+	gPattern.denormalized = buffer.String()
+	if duplicate != "" && strings.Contains(input, "|") {
+		// Names shared by the branches of an alternation are not duplicates,
+		// the positions are those of the first name defined twice
+		if name := duplicateCapture(gPattern.denormalized, nil); name != duplicate {
+			duplicate = name
+			offsets = []int{origins[name]}
+		}
+	}
+	if duplicate != "" {
+		return nil, fmt.Errorf("pattern `%s`: %w: `%s` at offsets %s",
+			input, ErrDuplicateCaptureName, duplicate, joinOffsets(offsets))
+	}
+	if len(origins) > 0 {
+		gPattern.captures = make([]string, 0, len(origins))
+		for name := range origins {
+			gPattern.captures = append(gPattern.captures, name)
+		}
+	}
 	return gPattern, nil
 }
 
+// capturedNames returns the names of the capture groups of the denormalized
+// pattern once each, looked up in the expression when the pattern was not
+// built by denormalizeReferences
+func (g *GrokPattern) capturedNames() []string {
+	if g.captures != nil || !strings.Contains(g.denormalized, "<") {
+		return g.captures
+	}
+	var names []string
+	seen := map[string]bool{}
+	for _, m := range inlinePattern.FindAllStringSubmatch(g.denormalized, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// joinOffsets formats the distinct offsets of a duplicate capture name
+func joinOffsets(offsets []int) string {
+	var parts []string
+	for i, offset := range offsets {
+		if i > 0 && offset == offsets[i-1] {
+			continue
+		}
+		parts = append(parts, strconv.Itoa(offset))
+	}
+	return strings.Join(parts, ", ")
+}
+
 // DenormalizePatternsFromMap denormalizes patterns from a map.
 // Returns a map of valid denormalized patterns and a map of errors for invalid patterns.
 func DenormalizePatternsFromMap(m map[string]string, denormalized ...map[string]*GrokPattern) (map[string]*GrokPattern, map[string]string) {
+	return DenormalizePatternsFromMapWithOptions(m, denormalized)
+}
+
+// DenormalizePatternsFromMapWithOptions denormalizes patterns from a map like
+// DenormalizePatternsFromMap, configured by opts
+func DenormalizePatternsFromMapWithOptions(m map[string]string, denormalized []map[string]*GrokPattern, opts ...DenormalizeOption) (map[string]*GrokPattern, map[string]string) {
+	return denormalizeMap(m, PatternStorage(denormalized), newDenormalizeOptions(opts))
+}
+
+// ResolveAll denormalizes the patterns of m like DenormalizePatternsFromMap,
+// resolving the references that are not defined in m against storage. It
+// returns the valid patterns, including the patterns of storage they depend
+// on, and the errors of the invalid ones
+func ResolveAll(m map[string]string, storage PatternStorageIface) (map[string]*GrokPattern, map[string]string) {
+	return denormalizeMap(m, storage, newDenormalizeOptions(nil))
+}
+
+// denormalizeMap builds the dependency graph of the patterns of m, whose
+// references to patterns outside of m are looked up in storage, and
+// denormalizes it
+func denormalizeMap(m map[string]string, storage PatternStorageIface, o denormalizeOptions) (map[string]*GrokPattern, map[string]string) {
 	patternDeps := map[string]*nodeP{}
 
 	for key, value := range m {
 		node := &nodeP{
 			cnt:   value,
 			cNode: []string{},
+			refs:  o.reference().FindAllStringSubmatchIndex(value, -1),
 		}
 
-		// Find sub-patterns that this pattern depends on
-		for _, match := range normalPattern.FindAllStringSubmatch(value, -1) {
-			names := strings.Split(match[1], ":")
-			syntax := names[0]
+		// Find sub-patterns that this pattern depends on, each once
+		seen := map[string]bool{}
+		for _, loc := range node.refs {
+			if len(loc) < 4 || loc[2] < 0 {
+				continue
+			}
+			syntax := strings.SplitN(value[loc[2]:loc[3]], ":", 2)[0]
+			if seen[syntax] {
+				continue
+			}
+			seen[syntax] = true
+
+			node.cNode = append(node.cNode, syntax)
 
-			// Check if the dependency exists in the input map
-			if _, ok := m[syntax]; ok {
-				node.cNode = append(node.cNode, syntax)
-			} else {
-				// Check if it exists in the denormalized patterns
-				found := false
-				for _, v := range denormalized {
-					if deV, ok := v[syntax]; ok {
-						node.cNode = append(node.cNode, syntax)
-						patternDeps[syntax] = &nodeP{
-							cnt: syntax,
-							ptn: deV,
-						}
-						found = true
-						break
-					}
-				}
-				if !found {
-					// Dependency will be caught as missing during tree processing
-					node.cNode = append(node.cNode, syntax)
+			// A dependency outside of the input map is taken from the storage,
+			// a missing one is caught during tree processing
+			if _, ok := m[syntax]; ok || storage == nil {
+				continue
+			}
+			if deV, ok := storage.GetPattern(syntax); ok && deV != nil {
+				patternDeps[syntax] = &nodeP{
+					cnt: syntax,
+					ptn: deV,
 				}
 			}
 		}
 		patternDeps[key] = node
 	}
 
-	return runTree(patternDeps)
+	return runTree(patternDeps, o)
+}
+
+// QuoteLiteral returns a grok pattern matching the literal text s. Like
+// regexp.QuoteMeta it escapes the regular expression metacharacters, and it
+// also escapes "%" so that no part of s can be taken for a %{NAME} pattern
+// reference
+func QuoteLiteral(s string) string {
+	return strings.ReplaceAll(regexp.QuoteMeta(s), "%", `\%`)
+}
+
+// DelimitedField returns a regular expression snippet capturing, as the field
+// name, the text up to the first occurrence of any character of delim, e.g.
+// DelimitedField("agent", ",") returns (?P<agent>[^,]*). The snippet can be
+// embedded in a grok pattern like any inline capture group
+func DelimitedField(name, delim string) string {
+	var buffer bytes.Buffer
+	buffer.WriteString("(?P<")
+	buffer.WriteString(symbolicPattern.ReplaceAllString(name, "_"))
+	buffer.WriteString(">[^")
+	for _, r := range delim {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			buffer.WriteRune(r)
+		case r < utf8.RuneSelf && (unicode.IsPunct(r) || unicode.IsSymbol(r)):
+			buffer.WriteByte('\\')
+			buffer.WriteRune(r)
+		default:
+			fmt.Fprintf(&buffer, `\x{%x}`, r)
+		}
+	}
+	buffer.WriteString("]*)")
+	return buffer.String()
 }
 
 // CopyDefalutPatterns returns a copy of the default patterns map
@@ -209,18 +936,135 @@ func CopyDefalutPatterns() map[string]string {
 	return ret
 }
 
+// FindPatternNames returns the sorted names of the default patterns matching
+// glob, using path.Match semantics. A malformed glob matches nothing
+func FindPatternNames(glob string) []string {
+	ret := []string{}
+	for k := range patterns {
+		if ok, err := pathpkg.Match(glob, k); err != nil {
+			return nil
+		} else if ok {
+			ret = append(ret, k)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// DiffPatterns compares two pattern maps and returns the sorted names of the
+// patterns added in b, removed from a and whose definition changed
+func DiffPatterns(a, b map[string]string) (added, removed, changed []string) {
+	for k, v := range b {
+		if old, ok := a[k]; !ok {
+			added = append(added, k)
+		} else if old != v {
+			changed = append(changed, k)
+		}
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
+
+// SameDenormalized reports whether two grok patterns denormalize to the same
+// regular expression once capture groups are ignored, e.g. %{IP:client} and
+// %{IP:server}. Both expressions are parsed so purely syntactic differences,
+// such as a redundant non-capturing group, do not matter
+func SameDenormalized(a, b string, storage PatternStorageIface) (bool, error) {
+	exprA, err := anonymousExpr(a, storage)
+	if err != nil {
+		return false, err
+	}
+	exprB, err := anonymousExpr(b, storage)
+	if err != nil {
+		return false, err
+	}
+	return exprA == exprB, nil
+}
+
+// anonymousExpr returns the canonical form of a denormalized pattern with all
+// capture groups removed
+func anonymousExpr(input string, storage PatternStorageIface) (string, error) {
+	gP, err := DenormalizePattern(input, storage)
+	if err != nil {
+		return "", err
+	}
+	re, err := resyntax.Parse(gP.denormalized, resyntax.Perl)
+	if err != nil {
+		return "", err
+	}
+	var strip func(*resyntax.Regexp) *resyntax.Regexp
+	strip = func(re *resyntax.Regexp) *resyntax.Regexp {
+		for re.Op == resyntax.OpCapture {
+			re = re.Sub[0]
+		}
+		for i, sub := range re.Sub {
+			re.Sub[i] = strip(sub)
+		}
+		return re
+	}
+	return strip(re).String(), nil
+}
+
+// PatternFields returns the names of the fields a pattern would capture, in
+// the order they appear in the pattern, and the declared types of those that
+// have one. The denormalized expression is only parsed, not compiled. A name
+// captured more than once is listed once
+func PatternFields(input string, storage PatternStorageIface) ([]string, map[string]string, error) {
+	gP, err := DenormalizePattern(input, storage)
+	if err != nil {
+		return nil, nil, err
+	}
+	re, err := resyntax.Parse(gP.denormalized, resyntax.Perl)
+	if err != nil {
+		return nil, nil, compileError(gP, err)
+	}
+
+	var names []string
+	types := map[string]string{}
+	seen := map[string]bool{}
+	for _, name := range re.CapNames() {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+		if varType, ok := gP.varbType[name]; ok {
+			types[name] = varType
+		}
+	}
+	return names, types, nil
+}
+
 // SubMatchName holds information about named submatches in a regex
 type SubMatchName struct {
 	name         []string
-	subexpIndex  []int
+	subexpIndex  [][]int
 	subexpCount  int
 }
 
 // GrokRegexp represents a compiled grok pattern as a regular expression
 type GrokRegexp struct {
 	grokPattern   *GrokPattern
-	re            *regexp.Regexp
+	re            CompiledRegex
 	subMatchNames SubMatchName
+	opts          compileOptions
+	renames       map[string]string
+	fieldPatterns map[string][]*regexp.Regexp
+	ranges        map[string]valueRange
+	multiValues   map[string]string
+	cache         *conversionCache
+}
+
+// valueRange is the inclusive range of values allowed for a numeric field
+type valueRange struct {
+	min, max float64
 }
 
 // MatchNames returns the list of named capture group names
@@ -228,37 +1072,470 @@ func (g *GrokRegexp) MatchNames() []string {
 	return g.subMatchNames.name
 }
 
-// Run executes the compiled pattern against the content string
-// Returns a slice of matched values corresponding to the named groups
-func (g *GrokRegexp) Run(content string, trimSpace bool) ([]string, error) {
-	if g.re == nil {
-		return nil, ErrNotCompiled
+// FieldSyntax returns the name of the pattern each field of MatchNames was
+// captured with, keyed by the field name used in the results. Fields captured
+// by a group written inline in the pattern are omitted
+func (g *GrokRegexp) FieldSyntax() map[string]string {
+	ret := map[string]string{}
+	for _, name := range g.subMatchNames.name {
+		if syntax, ok := g.grokPattern.fieldSyntax[name]; ok {
+			ret[g.outputName(name)] = syntax
+		}
 	}
+	return ret
+}
 
-	match := g.re.FindStringSubmatchIndex(content)
-	if len(match) == 0 {
-		return nil, ErrMismatch
-	}
-	if g.subMatchNames.subexpCount*2 != len(match) {
-		return nil, ErrMismatch
+// FieldTypes returns the type declared for each field of MatchNames, such as
+// "int" for %{INT:n:int}, keyed by the field name used in the results.
+// Fields without type annotation are omitted
+func (g *GrokRegexp) FieldTypes() map[string]string {
+	ret := map[string]string{}
+	for _, name := range g.subMatchNames.name {
+		if varType, ok := g.grokPattern.varbType[name]; ok {
+			ret[g.outputName(name)] = varType
+		}
 	}
-
+	return ret
+}
+
+// TypedFields returns the fields of MatchNames that have a type annotation,
+// in the order of MatchNames and named as in the results. Other fields are
+// captured as strings
+func (g *GrokRegexp) TypedFields() []string {
+	var ret []string
+	for _, name := range g.subMatchNames.name {
+		if _, ok := g.grokPattern.varbType[name]; ok {
+			ret = append(ret, g.outputName(name))
+		}
+	}
+	return ret
+}
+
+// SortedFieldNames returns the named capture group names sorted
+// alphabetically, MatchNames keeps them in the order of the pattern
+func (g *GrokRegexp) SortedFieldNames() []string {
+	names := append([]string(nil), g.subMatchNames.name...)
+	sort.Strings(names)
+	return names
+}
+
+// Match reports whether content matches the compiled pattern, without
+// converting any field. The values of fields constrained by AddFieldPattern
+// are checked, range constraints only apply to RunWithTypeInfoStrict
+func (g *GrokRegexp) Match(content string) bool {
+	if g.re == nil {
+		return false
+	}
+	content, err := g.input(content)
+	if err != nil {
+		return false
+	}
+	if len(g.fieldPatterns) == 0 {
+		return g.re.MatchString(content)
+	}
+
+	match := g.re.FindStringSubmatchIndex(content)
+	if match == nil {
+		return false
+	}
+	for i, name := range g.subMatchNames.name {
+		left, right := g.span(match, i)
+		if left == -1 || right == -1 {
+			continue
+		}
+		if g.checkField(name, content[left:right]) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// input prepares the content according to the compile options before it is
+// matched, content longer than the WithMaxInputLen limit is rejected
+func (g *GrokRegexp) input(content string) (string, error) {
+	if err := g.checkInputLen(len(content)); err != nil {
+		return "", err
+	}
+	if g.opts.trimLineEndings {
+		content = strings.TrimRight(content, "\r\n")
+	}
+	if g.opts.collapseWhitespace {
+		content = collapseWhitespace(content)
+	}
+	return content, nil
+}
+
+// checkInputLen returns ErrInputTooLarge when content of n bytes exceeds the
+// WithMaxInputLen limit
+func (g *GrokRegexp) checkInputLen(n int) error {
+	if g.opts.maxInputLen > 0 && n > g.opts.maxInputLen {
+		return fmt.Errorf("%w: %d bytes, limit is %d", ErrInputTooLarge, n, g.opts.maxInputLen)
+	}
+	return nil
+}
+
+// collapseWhitespace replaces every run of whitespace of content by a single
+// space
+func collapseWhitespace(content string) string {
+	var b strings.Builder
+	b.Grow(len(content))
+	space := false
+	for i := 0; i < len(content); {
+		r, size := utf8.DecodeRuneInString(content[i:])
+		if unicode.IsSpace(r) {
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+		} else {
+			b.WriteString(content[i : i+size])
+			space = false
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// Run executes the compiled pattern against the content string
+// Returns a slice of matched values corresponding to the named groups
+func (g *GrokRegexp) Run(content string, trimSpace bool) ([]string, error) {
+	result, _, err := g.RunWithRemainder(content, trimSpace)
+	return result, err
+}
+
+// RunBytes executes the compiled pattern like Run against content read as a
+// byte slice. The WithMaxInputLen limit is checked before content is copied
+func (g *GrokRegexp) RunBytes(content []byte, trimSpace bool) ([]string, error) {
+	if g.re == nil {
+		return nil, ErrNotCompiled
+	}
+	if err := g.checkInputLen(len(content)); err != nil {
+		return nil, err
+	}
+	return g.Run(string(content), trimSpace)
+}
+
+// RunWithRemainder executes the compiled pattern like Run and also returns the
+// part of the content that follows the match, so that it can be handed to
+// another pattern
+func (g *GrokRegexp) RunWithRemainder(content string, trimSpace bool) ([]string, string, error) {
+	return g.run(content, trimSpace, nil)
+}
+
+// run executes the compiled pattern like RunWithRemainder. When matched is
+// not nil, it is set to whether each field participated in the match
+func (g *GrokRegexp) run(content string, trimSpace bool, matched []bool) ([]string, string, error) {
+	if g.re == nil {
+		return nil, "", ErrNotCompiled
+	}
+
+	content, err := g.input(content)
+	if err != nil {
+		return nil, "", err
+	}
+	match := g.re.FindStringSubmatchIndex(content)
+	if len(match) == 0 {
+		return nil, "", ErrMismatch
+	}
+	if g.subMatchNames.subexpCount*2 != len(match) {
+		return nil, "", ErrMismatch
+	}
+
 	result := make([]string, len(g.subMatchNames.name))
 
 	for i := range g.subMatchNames.name {
-		idx := g.subMatchNames.subexpIndex[i]
+		left, right := g.span(match, i)
+		if left == -1 || right == -1 {
+			continue
+		}
+		if matched != nil {
+			matched[i] = true
+		}
+
+		result[i] = g.fieldValue(g.subMatchNames.name[i], content[left:right], trimSpace)
+		if err := g.checkField(g.subMatchNames.name[i], result[i]); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return result, content[match[1]:], nil
+}
+
+// RunStream executes the compiled pattern and calls emit with the name and
+// value of each field, in the order of MatchNames, without building the
+// slice of values returned by Run. A field that did not participate in the
+// match is emitted empty. RunStream stops at the first error returned by emit
+// and returns it. A value failing a constraint of AddFieldPattern stops it
+// with ErrFieldConstraint, the fields before it having been emitted already
+func (g *GrokRegexp) RunStream(content string, trimSpace bool, emit func(name, value string) error) error {
+	if g.re == nil {
+		return ErrNotCompiled
+	}
+
+	content, err := g.input(content)
+	if err != nil {
+		return err
+	}
+	match := g.re.FindStringSubmatchIndex(content)
+	if len(match) == 0 || g.subMatchNames.subexpCount*2 != len(match) {
+		return ErrMismatch
+	}
+
+	for i, name := range g.subMatchNames.name {
+		var value string
+		if left, right := g.span(match, i); left != -1 && right != -1 {
+			value = g.fieldValue(name, content[left:right], trimSpace)
+			if err := g.checkField(name, value); err != nil {
+				return err
+			}
+		}
+		if err := emit(g.outputName(name), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunSingle executes a pattern having exactly one field and returns the value
+// of that field, without building the slice of values returned by Run. The
+// flag is false when the field did not participate in the match. It fails
+// when the pattern has any other number of fields
+func (g *GrokRegexp) RunSingle(content string, trimSpace bool) (string, bool, error) {
+	if g.re == nil {
+		return "", false, ErrNotCompiled
+	}
+	if len(g.subMatchNames.name) != 1 {
+		return "", false, fmt.Errorf("RunSingle needs a pattern with exactly one field, got %d", len(g.subMatchNames.name))
+	}
+
+	content, err := g.input(content)
+	if err != nil {
+		return "", false, err
+	}
+	match := g.re.FindStringSubmatchIndex(content)
+	if len(match) == 0 || g.subMatchNames.subexpCount*2 != len(match) {
+		return "", false, ErrMismatch
+	}
+
+	left, right := g.span(match, 0)
+	if left == -1 || right == -1 {
+		return "", false, nil
+	}
+	name := g.subMatchNames.name[0]
+	value := g.fieldValue(name, content[left:right], trimSpace)
+	if err := g.checkField(name, value); err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// fieldValue post-processes the raw value captured for a field
+func (g *GrokRegexp) fieldValue(name, value string, trimSpace bool) string {
+	if trimSpace {
+		value = strings.TrimSpace(value)
+	}
+	if value == "-" && g.opts.isDashAsEmpty(name) {
+		value = ""
+	}
+	return value
+}
+
+// AddFieldPattern adds a constraint on the values captured for a field: a
+// match whose value for the field does not match re makes the Run methods
+// return ErrFieldConstraint and Match report false. Fields that do not
+// participate in a match are not checked. AddFieldPattern must not be called
+// concurrently with the Run methods
+func (g *GrokRegexp) AddFieldPattern(field string, re *regexp.Regexp) {
+	if g.fieldPatterns == nil {
+		g.fieldPatterns = map[string][]*regexp.Regexp{}
+	}
+	g.fieldPatterns[field] = append(g.fieldPatterns[field], re)
+}
+
+// AddRangeConstraint adds a constraint on the numeric value of a field: a
+// match whose converted value for the field is not within [min, max] makes
+// RunWithTypeInfoStrict return ErrFieldConstraint, e.g. to accept the ports
+// 1 to 65535 only. The value of an untyped field is parsed as a float, a
+// value that is not a number failing the constraint. Fields that do not
+// participate in a match are not checked, and a field constrained again
+// keeps the last range. AddRangeConstraint must not be called concurrently
+// with the Run methods
+func (g *GrokRegexp) AddRangeConstraint(field string, min, max float64) {
+	if g.ranges == nil {
+		g.ranges = map[string]valueRange{}
+	}
+	g.ranges[field] = valueRange{min: min, max: max}
+}
 
-		left := match[2*idx]
-		right := match[2*idx+1]
+// checkRange verifies a converted value against the range of its field
+func (g *GrokRegexp) checkRange(name string, value interface{}) error {
+	r, ok := g.ranges[name]
+	if !ok || value == nil {
+		return nil
+	}
+
+	var f float64
+	switch v := value.(type) {
+	case []interface{}:
+		for _, elem := range v {
+			if err := g.checkRange(name, elem); err != nil {
+				return err
+			}
+		}
+		return nil
+	case int64:
+		f = float64(v)
+	case float64:
+		f = v
+	case string:
+		var err error
+		if f, err = strconv.ParseFloat(v, 64); err != nil {
+			return fmt.Errorf("field `%s`: %w: %q is not a number", name, ErrFieldConstraint, v)
+		}
+	default:
+		return fmt.Errorf("field `%s`: %w: %v is not a number", name, ErrFieldConstraint, value)
+	}
+	if !(f >= r.min && f <= r.max) {
+		return fmt.Errorf("field `%s`: %w: %v is out of range [%v, %v]", name, ErrFieldConstraint, value, r.min, r.max)
+	}
+	return nil
+}
+
+// checkField verifies a captured value against the constraints of its field
+func (g *GrokRegexp) checkField(name, value string) error {
+	for _, re := range g.fieldPatterns[name] {
+		if !re.MatchString(value) {
+			return fmt.Errorf("field `%s`: %w: %q does not match `%s`", name, ErrFieldConstraint, value, re)
+		}
+	}
+	return nil
+}
+
+// span returns the bounds of the value of the field at position i in
+// MatchNames within match. A field captured by several groups, in different
+// branches of an alternation, takes the value of the group that participated
+// in the match
+func (g *GrokRegexp) span(match []int, i int) (int, int) {
+	for _, idx := range g.subMatchNames.subexpIndex[i] {
+		if match[2*idx] != -1 {
+			return match[2*idx], match[2*idx+1]
+		}
+	}
+	return -1, -1
+}
+
+// FieldIndex returns the position of a named field in MatchNames
+func (g *GrokRegexp) FieldIndex(name string) (int, bool) {
+	for i, n := range g.subMatchNames.name {
+		if n == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// RunFields executes the compiled pattern and extracts only the requested
+// fields. Fields that are not part of the pattern are left out of the result
+func (g *GrokRegexp) RunFields(content string, trimSpace bool, fields ...string) (map[string]string, error) {
+	if g.re == nil {
+		return nil, ErrNotCompiled
+	}
+
+	content, err := g.input(content)
+	if err != nil {
+		return nil, err
+	}
+	match := g.re.FindStringSubmatchIndex(content)
+	if len(match) == 0 {
+		return nil, ErrMismatch
+	}
+	if g.subMatchNames.subexpCount*2 != len(match) {
+		return nil, ErrMismatch
+	}
+
+	result := make(map[string]string, len(fields))
+	for _, field := range fields {
+		i, ok := g.FieldIndex(field)
+		if !ok {
+			continue
+		}
+		left, right := g.span(match, i)
 		if left == -1 || right == -1 {
+			result[field] = ""
 			continue
 		}
 
-		if trimSpace {
-			result[i] = strings.TrimSpace(content[left:right])
-		} else {
-			result[i] = content[left:right]
+		result[field] = g.fieldValue(field, content[left:right], trimSpace)
+		if err := g.checkField(field, result[field]); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// FieldAt matches content against the compiled pattern and returns the field
+// whose value covers the byte at offset in content, named as in the results.
+// When nested fields cover it, as for %{SYSLOGPROG:prog}, the innermost one is
+// returned. ok is false when no field covers the offset, and err is
+// ErrMismatch when content does not match. With WithCollapseWhitespace the
+// offset is one of the collapsed content
+func (g *GrokRegexp) FieldAt(content string, offset int) (string, bool, error) {
+	if g.re == nil {
+		return "", false, ErrNotCompiled
+	}
+
+	content, err := g.input(content)
+	if err != nil {
+		return "", false, err
+	}
+	match := g.re.FindStringSubmatchIndex(content)
+	if len(match) == 0 || g.subMatchNames.subexpCount*2 != len(match) {
+		return "", false, ErrMismatch
+	}
+
+	name, width := "", -1
+	for i, n := range g.subMatchNames.name {
+		left, right := g.span(match, i)
+		if left == -1 || offset < left || offset >= right {
+			continue
+		}
+		if width == -1 || right-left < width {
+			name, width = g.outputName(n), right-left
+		}
+	}
+	return name, width != -1, nil
+}
+
+// RunAllWithPositions finds every successive non-overlapping match of the
+// compiled pattern in content. Each element maps the field names of one match
+// to the [start, end) byte offsets of their value. Fields that did not
+// participate in a match are left out of its map
+func (g *GrokRegexp) RunAllWithPositions(content string) ([]map[string][2]int, error) {
+	if g.re == nil {
+		return nil, ErrNotCompiled
+	}
+
+	content, err := g.input(content)
+	if err != nil {
+		return nil, err
+	}
+	matches := g.re.FindAllStringSubmatchIndex(content, -1)
+	if len(matches) == 0 {
+		return nil, ErrMismatch
+	}
+
+	result := make([]map[string][2]int, 0, len(matches))
+	for _, match := range matches {
+		positions := make(map[string][2]int, len(g.subMatchNames.name))
+		for i, name := range g.subMatchNames.name {
+			left, right := g.span(match, i)
+			if left == -1 || right == -1 {
+				continue
+			}
+			positions[g.outputName(name)] = [2]int{left, right}
 		}
+		result = append(result, positions)
 	}
 
 	return result, nil
@@ -282,23 +1559,128 @@ func (g *GrokRegexp) WithTypeInfo() bool {
 	return len(g.grokPattern.varbType) > 0
 }
 
-// RunWithTypeInfo executes the pattern and converts matched values to their typed equivalents
+// RunWithTypeInfo executes the pattern and converts matched values to their
+// typed equivalents. Float and bool values that cannot be converted are 0 and
+// false, other values that cannot be converted are nil
 func (g *GrokRegexp) RunWithTypeInfo(content string, trimSpace bool) ([]interface{}, error) {
-	ret, err := g.Run(content, trimSpace)
+	castDst, _, _, err := g.runTyped(content, trimSpace, false)
+	return castDst, err
+}
+
+// RunWithTypeInfoStrict executes the pattern like RunWithTypeInfo but fails
+// with a *ConversionError when a value cannot be converted to the declared
+// type of its field, wrapping ErrUnknownType when the type has no converter,
+// and with ErrFieldConstraint when a converted value is out of the range set
+// by AddRangeConstraint. Typed fields that did not participate in the match
+// are nil
+func (g *GrokRegexp) RunWithTypeInfoStrict(content string, trimSpace bool) ([]interface{}, error) {
+	castDst, matched, errs, err := g.runTyped(content, trimSpace, true)
 	if err != nil {
 		return nil, err
 	}
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	for i, name := range g.subMatchNames.name {
+		if !matched[i] {
+			continue
+		}
+		if err := g.checkRange(name, castDst[i]); err != nil {
+			return nil, err
+		}
+	}
+	return castDst, nil
+}
+
+// runTyped executes the pattern and converts matched values to their typed
+// equivalents, also returning whether each field participated in the match.
+// The values that cannot be converted are given by lenientValue and their
+// conversion errors are returned in the order of MatchNames. Typed fields
+// that did not participate in the match are not converted: they are nil if
+// unmatchedNil is set, else the conversion of an empty value RunWithTypeInfo
+// has always returned, without error in both cases
+func (g *GrokRegexp) runTyped(content string, trimSpace, unmatchedNil bool) ([]interface{}, []bool, []*ConversionError, error) {
+	matched := make([]bool, len(g.subMatchNames.name))
+	ret, _, err := g.run(content, trimSpace, matched)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
 	castDst := make([]interface{}, len(g.subMatchNames.name))
+	var errs []*ConversionError
 
 	for i, name := range g.subMatchNames.name {
-		castDst[i], _ = g.GetValCastByName(name, ret)
+		varType := g.grokPattern.varbType[name]
+		if !matched[i] && unmatchedNil && varType != "" && varType != GTypeStr {
+			continue
+		}
+		v, err := g.castField(name, ret[i])
+		if err != nil {
+			var convErr *ConversionError
+			if matched[i] && errors.As(err, &convErr) {
+				errs = append(errs, convErr)
+			}
+			castDst[i] = v
+			continue
+		}
+		if g.opts.keepRaw[name] {
+			v = g.rawField(name, ret[i])
+		}
+		castDst[i] = v
 	}
 
-	return castDst, nil
+	return castDst, matched, errs, nil
+}
+
+// rawField returns the value of a field kept raw by WithKeepRaw in the typed
+// outputs, split into a []string for a multi-value field
+func (g *GrokRegexp) rawField(name, value string) interface{} {
+	sep, ok := g.multiValues[name]
+	if !ok {
+		return value
+	}
+	parts := []string{}
+	if value != "" {
+		parts = strings.Split(value, sep)
+	}
+	return parts
 }
 
-// GetValCastByName retrieves a matched value by name and converts it to its typed value
+// FieldResult holds the value of a field both as captured and as converted
+// to the declared type of the field
+type FieldResult struct {
+	Name  string
+	Raw   string
+	Typed interface{}
+	Err   error
+}
+
+// RunDetailed executes the compiled pattern and returns, in the order of
+// MatchNames, the captured value of each field along with its converted
+// value. Typed is the captured string for untyped fields, and is nil when the
+// conversion fails, Err holding the *ConversionError. Typed is the converted
+// value even for the fields kept raw by WithKeepRaw
+func (g *GrokRegexp) RunDetailed(content string, trimSpace bool) ([]FieldResult, error) {
+	ret, err := g.Run(content, trimSpace)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]FieldResult, len(ret))
+	for i, name := range g.subMatchNames.name {
+		results[i] = FieldResult{Name: g.outputName(name), Raw: ret[i]}
+		results[i].Typed, results[i].Err = g.castField(name, ret[i])
+		if results[i].Err != nil {
+			results[i].Typed = nil
+		}
+	}
+	return results, nil
+}
+
+// GetValCastByName retrieves a matched value by name and converts it to its
+// typed value. A field configured with SetMultiValue is returned as a slice.
+// Float and bool values that cannot be converted are returned as 0 and false
+// with ok set, other values that cannot be converted as nil and false
 func (g *GrokRegexp) GetValCastByName(k string, val []string) (interface{}, bool) {
 	if len(val) != len(g.subMatchNames.name) {
 		return nil, false
@@ -306,29 +1688,176 @@ func (g *GrokRegexp) GetValCastByName(k string, val []string) (interface{}, bool
 
 	for i, name := range g.subMatchNames.name {
 		if name == k {
-			if varType, ok := g.grokPattern.varbType[name]; ok {
-				var dstV interface{}
-				switch varType {
-				case GTypeInt:
-					dstV, _ = cast.ToInt64E(val[i])
-				case GTypeFloat:
-					dstV, _ = cast.ToFloat64E(val[i])
-				case GTypeBool:
-					dstV, _ = cast.ToBoolE(val[i])
-				case GTypeStr:
-					dstV = val[i]
-				default:
-					return nil, false
-				}
-				return dstV, true
-			} else {
-				return val[i], true
-			}
+			v, err := g.castField(name, val[i])
+			return v, err == nil || v != nil
 		}
 	}
 	return nil, false
 }
 
+// ConversionError reports a captured value that could not be converted to
+// the declared type of its field. It matches ErrConversion with errors.Is
+type ConversionError struct {
+	Field string
+	Type  string
+	Value string
+	Err   error
+}
+
+// Error returns the field, value and type of the failed conversion
+func (e *ConversionError) Error() string {
+	return fmt.Sprintf("field `%s`: %s: %q is not a valid %s: %v", e.Field, ErrConversion, e.Value, e.Type, e.Err)
+}
+
+// Unwrap returns the error reported by the converter
+func (e *ConversionError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrConversion
+func (e *ConversionError) Is(target error) bool {
+	return target == ErrConversion
+}
+
+// castField converts a value captured for a field, splitting it first when
+// the field is configured with SetMultiValue
+func (g *GrokRegexp) castField(name, value string) (interface{}, error) {
+	if sep, ok := g.multiValues[name]; ok {
+		return g.castValues(name, value, sep)
+	}
+	return g.castValue(name, value)
+}
+
+// castValue converts a value captured for a field to the declared type of the
+// field, values of untyped fields are returned unchanged. A value that cannot
+// be converted is returned as given by lenientValue along with the error
+func (g *GrokRegexp) castValue(name, value string) (interface{}, error) {
+	varType, ok := g.grokPattern.varbType[name]
+	if !ok {
+		return value, nil
+	}
+
+	var dstV interface{}
+	var err error
+	if g.cache != nil && varType != GTypeJSON && varType != GTypeMAC {
+		var found bool
+		if dstV, err, found = g.cache.get(name, value); !found {
+			dstV, err = g.convert(varType, value)
+			g.cache.put(name, value, dstV, err)
+		}
+	} else {
+		dstV, err = g.convert(varType, value)
+	}
+	if err != nil {
+		return lenientValue(varType), &ConversionError{Field: name, Type: varType, Value: value, Err: err}
+	}
+	return dstV, nil
+}
+
+// lenientValue returns the value the lenient typed outputs give to a value
+// that cannot be converted to varType: 0 for floats and false for bools, as
+// they always have, and nil for the other types
+func lenientValue(varType string) interface{} {
+	switch varType {
+	case GTypeFloat:
+		return float64(0)
+	case GTypeBool:
+		return false
+	}
+	return nil
+}
+
+// convert converts a value to the given type
+func (g *GrokRegexp) convert(varType, value string) (interface{}, error) {
+	switch varType {
+	case GTypeInt:
+		return parseInt(g.normalizeNumber(value))
+	case GTypeFloat:
+		return cast.ToFloat64E(g.normalizeNumber(value))
+	case GTypeBool:
+		return cast.ToBoolE(value)
+	case GTypeDuration:
+		return time.ParseDuration(value)
+	case GTypeJSON:
+		var dstV interface{}
+		if err := json.Unmarshal([]byte(value), &dstV); err != nil {
+			return nil, err
+		}
+		return dstV, nil
+	case GTypeMAC:
+		return net.ParseMAC(value)
+	case GTypeTimestamp:
+		return parseTime(value, g.opts.location)
+	case GTypeStr:
+		return value, nil
+	}
+	return nil, fmt.Errorf("%w %s", ErrUnknownType, varType)
+}
+
+// castValues splits a value captured for a multi-value field. The elements
+// are returned as a []string for untyped and string fields, otherwise as an
+// []interface{} of values converted to the declared type
+func (g *GrokRegexp) castValues(name, value, sep string) (interface{}, error) {
+	var parts []string
+	if value != "" {
+		parts = strings.Split(value, sep)
+	}
+
+	if varType, ok := g.grokPattern.varbType[name]; !ok || varType == GTypeStr {
+		if parts == nil {
+			parts = []string{}
+		}
+		return parts, nil
+	}
+
+	// As a single value, a float or bool element that cannot be converted
+	// is kept as 0 or false along with the error
+	ret := make([]interface{}, len(parts))
+	var convErr error
+	for i, part := range parts {
+		v, err := g.castValue(name, part)
+		if err != nil {
+			if v == nil {
+				return nil, err
+			}
+			if convErr == nil {
+				convErr = err
+			}
+		}
+		ret[i] = v
+	}
+	return ret, convErr
+}
+
+// parseInt converts an int capture. Values with a 0x, 0o or 0b prefix are
+// parsed in that base, other values are decimal even with leading zeros
+func parseInt(s string) (int64, error) {
+	digits := strings.TrimLeft(s, "+-")
+	if len(digits) > 2 && digits[0] == '0' && strings.ContainsRune("xXoObB", rune(digits[1])) {
+		return strconv.ParseInt(s, 0, 64)
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	return cast.ToInt64E(s)
+}
+
+// normalizeNumber rewrites a numeric capture according to the compile options
+// before it is converted
+func (g *GrokRegexp) normalizeNumber(s string) string {
+	decimalSep := '.'
+	if g.opts.decimalComma {
+		decimalSep = ','
+	}
+	if sep := g.opts.thousandsSep; sep != 0 && sep != decimalSep {
+		s = strings.Replace(s, string(sep), "", -1)
+	}
+	if g.opts.decimalComma {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	return s
+}
+
 // GetValAnyByName retrieves a matched value by name from a slice of any type
 func (g *GrokRegexp) GetValAnyByName(k string, val []interface{}) (interface{}, bool) {
 	if len(val) != len(g.subMatchNames.name) {
@@ -342,73 +1871,696 @@ func (g *GrokRegexp) GetValAnyByName(k string, val []interface{}) (interface{},
 	return "", false
 }
 
-// CompilePattern compiles a grok pattern into a GrokRegexp
-func CompilePattern(input string, denormalized PatternStorageIface) (*GrokRegexp, error) {
-	gP, err := DenormalizePattern(input, denormalized)
+// Rename makes the map and JSON outputs use newName as the key of the field
+// captured as oldName. It does not affect the compiled regular expression nor
+// the lookups by capture name such as GetValByName. Rename must not be called
+// concurrently with the Run methods
+func (g *GrokRegexp) Rename(oldName, newName string) {
+	if g.renames == nil {
+		g.renames = map[string]string{}
+	}
+	g.renames[oldName] = newName
+}
+
+// SetMultiValue makes the typed outputs, RunWithTypeInfo, RunMapWithTypeInfo
+// and RunJSON, split the value captured for field on sep and return the
+// parts as a slice, converted to the type of the field when it has one.
+// Outputs returning strings, such as RunMap, keep the whole value.
+// SetMultiValue must not be called concurrently with the Run methods
+func (g *GrokRegexp) SetMultiValue(field, sep string) {
+	if g.multiValues == nil {
+		g.multiValues = map[string]string{}
+	}
+	g.multiValues[field] = sep
+}
+
+// Reset discards the configuration applied to the GrokRegexp after it was
+// compiled, such as renamed fields or field constraints, and clears its
+// internal caches. The compile options are kept. Reset must not be called
+// concurrently with the Run methods
+func (g *GrokRegexp) Reset() {
+	g.renames = nil
+	g.fieldPatterns = nil
+	g.ranges = nil
+	g.multiValues = nil
+	if g.cache != nil {
+		g.cache.clear()
+	}
+}
+
+// Recompile denormalizes the grok pattern the GrokRegexp was compiled from
+// again, resolving its references against storage, and compiles it with the
+// same compile options. The configuration applied after compilation, such as
+// renamed fields or field constraints, is carried over to the returned
+// GrokRegexp, the receiver is left unchanged. It lets a pattern pick up new
+// definitions of the patterns it references, e.g. after PatternStorage.Update
+func (g *GrokRegexp) Recompile(storage PatternStorageIface) (*GrokRegexp, error) {
+	if g.grokPattern == nil {
+		return nil, ErrNotCompiled
+	}
+
+	gr, err := compilePattern(g.grokPattern.pattern, storage, g.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for oldName, newName := range g.renames {
+		gr.Rename(oldName, newName)
+	}
+	for field, res := range g.fieldPatterns {
+		for _, re := range res {
+			gr.AddFieldPattern(field, re)
+		}
+	}
+	for field, r := range g.ranges {
+		gr.AddRangeConstraint(field, r.min, r.max)
+	}
+	for field, sep := range g.multiValues {
+		gr.SetMultiValue(field, sep)
+	}
+	return gr, nil
+}
+
+// outputName returns the key used for a field in the map and JSON outputs
+func (g *GrokRegexp) outputName(name string) string {
+	if newName, ok := g.renames[name]; ok {
+		return newName
+	}
+	return name
+}
+
+// RunMap executes the compiled pattern and returns the matched values keyed by
+// field name
+func (g *GrokRegexp) RunMap(content string, trimSpace bool) (map[string]string, error) {
+	ret, err := g.Run(content, trimSpace)
 	if err != nil {
 		return nil, err
 	}
-	
-	re, err := regexp.Compile(gP.denormalized)
+
+	result := make(map[string]string, len(ret))
+	for i, name := range g.subMatchNames.name {
+		result[g.outputName(name)] = ret[i]
+	}
+	return result, nil
+}
+
+// RunMany executes the compiled pattern against each of lines like RunMap,
+// spreading the lines over concurrency goroutines, GOMAXPROCS when it is not
+// positive. The results and errors are in the order of lines, the error of a
+// matched line being nil
+func (g *GrokRegexp) RunMany(lines []string, trimSpace bool, concurrency int) ([]map[string]string, []error) {
+	results := make([]map[string]string, len(lines))
+	errs := make([]error, len(lines))
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	if concurrency > len(lines) {
+		concurrency = len(lines)
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i], errs[i] = g.RunMap(lines[i], trimSpace)
+			}
+		}()
+	}
+	for i := range lines {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results, errs
+}
+
+// Pair is a field name and its matched value
+type Pair struct {
+	Name  string
+	Value string
+}
+
+// RunPairs executes the compiled pattern and returns the matched values with
+// their field name, in the order of MatchNames
+func (g *GrokRegexp) RunPairs(content string, trimSpace bool) ([]Pair, error) {
+	ret, err := g.Run(content, trimSpace)
 	if err != nil {
 		return nil, err
 	}
 
-	var subMatchNames SubMatchName
-	for i, name := range re.SubexpNames() {
-		if name != "" {
-			// Update index for duplicate names
-			for j := range subMatchNames.name {
-				if subMatchNames.name[j] == name {
-					subMatchNames.subexpIndex[j] = i
-					break
+	result := make([]Pair, len(ret))
+	for i, name := range g.subMatchNames.name {
+		result[i] = Pair{Name: g.outputName(name), Value: ret[i]}
+	}
+	return result, nil
+}
+
+// TypedPair is a field name, its typed value and its declared type, "" when
+// the field has no type annotation
+type TypedPair struct {
+	Name  string
+	Value interface{}
+	Type  string
+}
+
+// RunTypedPairs executes the compiled pattern and returns the values of the
+// fields converted like RunWithTypeInfo, with their field name and declared
+// type, in the order of MatchNames
+func (g *GrokRegexp) RunTypedPairs(content string, trimSpace bool) ([]TypedPair, error) {
+	ret, err := g.RunWithTypeInfo(content, trimSpace)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]TypedPair, len(ret))
+	for i, name := range g.subMatchNames.name {
+		result[i] = TypedPair{Name: g.outputName(name), Value: ret[i], Type: g.grokPattern.varbType[name]}
+	}
+	return result, nil
+}
+
+// RunAllMap finds every successive non-overlapping match of the compiled
+// pattern in content and returns the matched values of each match keyed by
+// field name. Every map holds all the fields, those that did not participate
+// in a match are empty
+func (g *GrokRegexp) RunAllMap(content string, trimSpace bool) ([]map[string]string, error) {
+	if g.re == nil {
+		return nil, ErrNotCompiled
+	}
+
+	content, err := g.input(content)
+	if err != nil {
+		return nil, err
+	}
+	matches := g.re.FindAllStringSubmatchIndex(content, -1)
+	if len(matches) == 0 {
+		return nil, ErrMismatch
+	}
+
+	result := make([]map[string]string, 0, len(matches))
+	for _, match := range matches {
+		values := make(map[string]string, len(g.subMatchNames.name))
+		for i, name := range g.subMatchNames.name {
+			left, right := g.span(match, i)
+			if left == -1 || right == -1 {
+				values[g.outputName(name)] = ""
+				continue
+			}
+
+			value := g.fieldValue(name, content[left:right], trimSpace)
+			if err := g.checkField(name, value); err != nil {
+				return nil, err
+			}
+			values[g.outputName(name)] = value
+		}
+		result = append(result, values)
+	}
+
+	return result, nil
+}
+
+// RunMapWithTypeInfo executes the compiled pattern and returns the matched
+// values converted to their declared types, keyed by field name
+func (g *GrokRegexp) RunMapWithTypeInfo(content string, trimSpace bool) (map[string]interface{}, error) {
+	ret, err := g.RunWithTypeInfo(content, trimSpace)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]interface{}, len(ret))
+	for i, name := range g.subMatchNames.name {
+		result[g.outputName(name)] = ret[i]
+	}
+	return result, nil
+}
+
+// RunMapWithTypeInfoCompact executes the compiled pattern like
+// RunMapWithTypeInfo but leaves out the fields whose value cannot be
+// converted to their declared type, instead of returning them as nil. The
+// conversion errors of the dropped fields are returned alongside the map
+func (g *GrokRegexp) RunMapWithTypeInfoCompact(content string, trimSpace bool) (map[string]interface{}, []*ConversionError, error) {
+	ret, _, errs, err := g.runTyped(content, trimSpace, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dropped := make(map[string]bool, len(errs))
+	for _, convErr := range errs {
+		dropped[convErr.Field] = true
+	}
+
+	result := make(map[string]interface{}, len(ret))
+	for i, name := range g.subMatchNames.name {
+		if !dropped[name] {
+			result[g.outputName(name)] = ret[i]
+		}
+	}
+	return result, errs, nil
+}
+
+// RunJSON executes the compiled pattern and returns the typed matched values
+// encoded as a JSON object
+func (g *GrokRegexp) RunJSON(content string, trimSpace bool) ([]byte, error) {
+	result, err := g.RunMapWithTypeInfo(content, trimSpace)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range result {
+		result[k] = jsonValue(v)
+	}
+	return json.Marshal(result)
+}
+
+// RunJSONRaw executes the compiled pattern like RunJSON, but the value of an
+// int or float field is written as it was captured when that text is a JSON
+// number, so that integers beyond the range of int64 and floats with more
+// digits than a float64 holds keep their exact value. Only integer literals
+// are copied for int fields, other values are converted as in RunJSON. The
+// value of a json field is copied as captured when it is valid JSON and is
+// null otherwise. Other fields are encoded as in RunJSON, with null for the
+// values that cannot be converted
+func (g *GrokRegexp) RunJSONRaw(content string, trimSpace bool) ([]byte, error) {
+	ret, err := g.Run(content, trimSpace)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]json.RawMessage, len(ret))
+	for i, name := range g.subMatchNames.name {
+		if sep, ok := g.multiValues[name]; ok {
+			parts := []json.RawMessage{}
+			if ret[i] != "" {
+				for _, part := range strings.Split(ret[i], sep) {
+					parts = append(parts, g.rawJSONValue(name, part))
 				}
 			}
+			result[g.outputName(name)], _ = json.Marshal(parts)
+			continue
+		}
+		result[g.outputName(name)] = g.rawJSONValue(name, ret[i])
+	}
+	return json.Marshal(result)
+}
 
-			// Insert name and index
-			subMatchNames.name = append(subMatchNames.name, name)
-			subMatchNames.subexpIndex = append(subMatchNames.subexpIndex, i)
+// rawJSONValue encodes the value captured for a field for RunJSONRaw, values
+// that cannot be converted or encoded are null
+func (g *GrokRegexp) rawJSONValue(name, value string) json.RawMessage {
+	if g.opts.keepRaw[name] {
+		if _, err := g.castValue(name, value); err != nil {
+			return json.RawMessage("null")
 		}
+		raw, _ := json.Marshal(value)
+		return raw
 	}
 
-	subMatchNames.subexpCount = len(re.SubexpNames())
+	switch g.grokPattern.varbType[name] {
+	case GTypeInt:
+		if n := g.normalizeNumber(value); jsonInteger.MatchString(n) {
+			return json.RawMessage(n)
+		}
+	case GTypeFloat:
+		if n := g.normalizeNumber(value); jsonNumber.MatchString(n) {
+			return json.RawMessage(n)
+		}
+	case GTypeJSON:
+		if json.Valid([]byte(value)) {
+			return json.RawMessage(value)
+		}
+		return json.RawMessage("null")
+	}
 
-	return &GrokRegexp{
-		grokPattern:   gP,
-		re:            re,
-		subMatchNames: subMatchNames,
-	}, nil
+	v, err := g.castValue(name, value)
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	// NaN and infinite floats have no JSON encoding
+	raw, err := json.Marshal(jsonValue(v))
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return raw
+}
+
+// jsonValue returns the value encoded by RunJSON for a typed value, MAC
+// addresses are encoded in their string form rather than as bytes
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case net.HardwareAddr:
+		return v.String()
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonValue(v[i])
+		}
+	}
+	return v
+}
+
+// RunCSV executes the compiled pattern and writes the matched values to w as
+// one CSV record, in the order of MatchNames. The writer is not flushed
+func (g *GrokRegexp) RunCSV(content string, trimSpace bool, w *csv.Writer) error {
+	ret, err := g.Run(content, trimSpace)
+	if err != nil {
+		return err
+	}
+	return w.Write(ret)
+}
+
+// WriteCSVHeader writes to w the CSV record of the field names matching the
+// records written by RunCSV. The writer is not flushed
+func (g *GrokRegexp) WriteCSVHeader(w *csv.Writer) error {
+	header := make([]string, len(g.subMatchNames.name))
+	for i, name := range g.subMatchNames.name {
+		header[i] = g.outputName(name)
+	}
+	return w.Write(header)
+}
+
+// compileErrorContext is the number of bytes of the denormalized expression
+// shown on each side of the fragment rejected by the regexp compiler
+const compileErrorContext = 20
+
+// compileError wraps an error returned by regexp.Compile with the grok
+// pattern it was produced from and, when it can be located, an excerpt of
+// the denormalized expression around the offending fragment
+func compileError(gP *GrokPattern, err error) error {
+	var synErr *resyntax.Error
+	if errors.As(err, &synErr) && synErr.Expr != "" {
+		if i := strings.Index(gP.denormalized, synErr.Expr); i >= 0 {
+			start := i - compileErrorContext
+			if start < 0 {
+				start = 0
+			}
+			end := i + len(synErr.Expr) + compileErrorContext
+			if end > len(gP.denormalized) {
+				end = len(gP.denormalized)
+			}
+			return fmt.Errorf("pattern `%s`: %w: near `%s`", gP.pattern, err, gP.denormalized[start:end])
+		}
+	}
+	return fmt.Errorf("pattern `%s`: %w", gP.pattern, err)
+}
+
+// CompilePattern compiles a grok pattern into a GrokRegexp. The branches of an
+// alternation may capture the same field, as in %{IPV4:ip}|%{IPV6:ip}, the
+// field then holds the value captured by the branch that matched. A field
+// captured more than once by the same match fails with
+// ErrDuplicateCaptureName, although the regexp package accepts it: earlier
+// versions compiled such patterns and kept the value of the last group
+func CompilePattern(input string, denormalized PatternStorageIface, opts ...CompileOption) (*GrokRegexp, error) {
+	return compilePattern(input, denormalized, newCompileOptions(opts))
+}
+
+// CompilePatternAlternatives compiles the variants of a log format into a
+// single GrokRegexp matching any of them, so that one object handles a format
+// whose lines come in a few shapes. MatchNames is the union of the fields of
+// the variants, in order of first appearance, a field of the variants that
+// did not match being empty. The variants are tried as the branches of an
+// alternation: the match starting first in the content wins, and among the
+// variants matching at the same position the first one. Variants anchored
+// with ^ are thus tried in order. A field declared with different types by
+// two variants fails the compilation
+func CompilePatternAlternatives(inputs []string, denormalized PatternStorageIface, opts ...CompileOption) (*GrokRegexp, error) {
+	if len(inputs) == 0 {
+		return nil, errors.New("no pattern alternative")
+	}
+
+	o := denormalizeOptions{foreignSyntax: newCompileOptions(opts).engine != nil}
+	branches := make([]string, len(inputs))
+	types := map[string]string{}
+	for i, input := range inputs {
+		gP, err := denormalizePattern(input, denormalized, o)
+		if err != nil {
+			return nil, fmt.Errorf("alternative %d: %w", i, err)
+		}
+		for name, varType := range gP.varbType {
+			if cur, ok := types[name]; ok && cur != varType {
+				return nil, fmt.Errorf("alternative %d: conflicting data types for `%s`: `%s` and `%s`", i, name, cur, varType)
+			}
+			types[name] = varType
+		}
+		branches[i] = "(?:" + input + ")"
+	}
+	return CompilePattern(strings.Join(branches, "|"), denormalized, opts...)
+}
+
+// compilePattern denormalizes and compiles input with the given options
+func compilePattern(input string, denormalized PatternStorageIface, o compileOptions) (*GrokRegexp, error) {
+	start := time.Now()
+	gP, err := denormalizePattern(input, denormalized, denormalizeOptions{foreignSyntax: o.engine != nil})
+	if o.observer != nil {
+		o.observer.OnDenormalize(input, time.Since(start))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return compileGrokPattern(gP, o)
+}
+
+// CompilePatternVerbose compiles a grok pattern like CompilePattern and also
+// returns warnings about type annotations that have no effect or can never
+// produce a valid value. Warnings never make the compilation fail
+func CompilePatternVerbose(input string, denormalized PatternStorageIface, opts ...CompileOption) (*GrokRegexp, []Warning, error) {
+	co := newCompileOptions(opts)
+	o := newDenormalizeOptions([]DenormalizeOption{WithStrictTypes()})
+	o.redundantTypes = true
+	o.foreignSyntax = co.engine != nil
+
+	start := time.Now()
+	gP, err := denormalizePattern(input, denormalized, o)
+	if co.observer != nil {
+		co.observer.OnDenormalize(input, time.Since(start))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gr, err := compileGrokPattern(gP, co)
+	if err != nil {
+		return nil, nil, err
+	}
+	return gr, gP.Warnings(), nil
+}
+
+// CompileFromMap compiles a grok pattern against the given pattern
+// definitions merged into the default patterns, so definitions in defs take
+// precedence, also in the default patterns referencing them. If any
+// definition is invalid, an error listing all of them is returned
+func CompileFromMap(input string, defs map[string]string, opts ...CompileOption) (*GrokRegexp, error) {
+	merged := CopyDefalutPatterns()
+	for name, def := range defs {
+		merged[name] = def
+	}
+
+	valid, invalid := DenormalizePatternsFromMap(merged)
+	if len(invalid) != 0 {
+		return nil, invalidPatternsError(invalid)
+	}
+
+	return CompilePattern(input, PatternStorage{valid}, opts...)
+}
+
+// CompileAll compiles each input pattern independently. It returns the
+// compiled patterns and the compilation errors, both keyed by the name given
+// to the pattern in inputs, so a single invalid pattern does not prevent the
+// others from being used
+func CompileAll(inputs map[string]string, denormalized PatternStorageIface, opts ...CompileOption) (map[string]*GrokRegexp, map[string]error) {
+	compiled := make(map[string]*GrokRegexp, len(inputs))
+	errs := map[string]error{}
+	for name, input := range inputs {
+		gr, err := CompilePattern(input, denormalized, opts...)
+		if err != nil {
+			errs[name] = err
+			continue
+		}
+		compiled[name] = gr
+	}
+	return compiled, errs
+}
+
+// InvalidPattern is a pattern that failed to denormalize and the reason why
+type InvalidPattern struct {
+	Name  string
+	Error string
+}
+
+// SortedInvalid returns the invalid patterns reported by
+// DenormalizePatternsFromMap sorted by name, for deterministic reports
+func SortedInvalid(invalid map[string]string) []InvalidPattern {
+	ret := make([]InvalidPattern, 0, len(invalid))
+	for name, msg := range invalid {
+		ret = append(ret, InvalidPattern{Name: name, Error: msg})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// invalidPatternsError combines the errors reported for invalid patterns
+// into a single error, sorted by pattern name
+func invalidPatternsError(invalid map[string]string) error {
+	msgs := make([]string, 0, len(invalid))
+	for _, ip := range SortedInvalid(invalid) {
+		msgs = append(msgs, ip.Name+": "+ip.Error)
+	}
+	return fmt.Errorf("invalid pattern definitions: %s", strings.Join(msgs, "; "))
 }
 
 // CompilePattern2 compiles a pre-denormalized GrokPattern into a GrokRegexp
-func CompilePattern2(gP *GrokPattern, denormalized PatternStorageIface) (*GrokRegexp, error) {
-	re, err := regexp.Compile(gP.denormalized)
+func CompilePattern2(gP *GrokPattern, denormalized PatternStorageIface, opts ...CompileOption) (*GrokRegexp, error) {
+	return compileGrokPattern(gP, newCompileOptions(opts))
+}
+
+// compileGrokPattern builds the regular expression and named group index of a
+// denormalized pattern
+func compileGrokPattern(gP *GrokPattern, o compileOptions) (*GrokRegexp, error) {
+	if o.maxDenormalizedLen > 0 && len(gP.denormalized) > o.maxDenormalizedLen {
+		err := fmt.Errorf("pattern `%s`: %w: %d bytes exceeds the limit of %d",
+			gP.pattern, ErrPatternTooLarge, len(gP.denormalized), o.maxDenormalizedLen)
+		if o.observer != nil {
+			o.observer.OnCompile(gP.pattern, 0, err)
+		}
+		return nil, err
+	}
+	if o.maxRepeat > 0 {
+		if count, repeat := largestRepeat(gP.denormalized); count > o.maxRepeat {
+			err := fmt.Errorf("pattern `%s`: %w: `%s` repeats up to %d times, exceeding the limit of %d",
+				gP.pattern, ErrPatternTooLarge, repeat, count, o.maxRepeat)
+			if o.observer != nil {
+				o.observer.OnCompile(gP.pattern, 0, err)
+			}
+			return nil, err
+		}
+	}
+
+	expr := gP.denormalized
+	if o.anchorStart {
+		expr = `\A(?:` + expr + `)`
+	}
+	if o.anchorEnd {
+		expr = `(?:` + expr + `)\z`
+	}
+
+	start := time.Now()
+	re, err := o.regexEngine().Compile(expr)
+	if err != nil {
+		err = compileError(gP, err)
+	}
+	if o.observer != nil {
+		o.observer.OnCompile(gP.pattern, time.Since(start), err)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	if name := duplicateCapture(expr, re.SubexpNames()); name != "" {
+		return nil, fmt.Errorf("pattern `%s`: %w: `%s`", gP.pattern, ErrDuplicateCaptureName, name)
+	}
+
 	var subMatchNames SubMatchName
+	position := map[string]int{}
 	for i, name := range re.SubexpNames() {
-		if name != "" {
-			// Update index for duplicate names
-			for j := range subMatchNames.name {
-				if subMatchNames.name[j] == name {
-					subMatchNames.subexpIndex[j] = i
-					break
-				}
-			}
+		if name == "" {
+			continue
+		}
+		// Groups sharing a name in different branches of an alternation
+		// make a single field
+		if j, ok := position[name]; ok {
+			subMatchNames.subexpIndex[j] = append(subMatchNames.subexpIndex[j], i)
+			continue
+		}
+		position[name] = len(subMatchNames.name)
+		subMatchNames.name = append(subMatchNames.name, name)
+		subMatchNames.subexpIndex = append(subMatchNames.subexpIndex, []int{i})
+	}
 
-			// Insert name and index
-			subMatchNames.name = append(subMatchNames.name, name)
-			subMatchNames.subexpIndex = append(subMatchNames.subexpIndex, i)
+	// The whole match is reported as the submatch of index 0
+	if o.fullMatchField != "" {
+		if _, ok := position[o.fullMatchField]; ok {
+			return nil, fmt.Errorf("pattern `%s`: %w: `%s`", gP.pattern, ErrDuplicateCaptureName, o.fullMatchField)
 		}
+		subMatchNames.name = append(subMatchNames.name, o.fullMatchField)
+		subMatchNames.subexpIndex = append(subMatchNames.subexpIndex, []int{0})
 	}
 
 	subMatchNames.subexpCount = len(re.SubexpNames())
 
-	return &GrokRegexp{
+	gr := &GrokRegexp{
 		grokPattern:   gP,
 		re:            re,
 		subMatchNames: subMatchNames,
-	}, nil
+		opts:          o,
+	}
+	if o.conversionCache > 0 {
+		gr.cache = newConversionCache(o.conversionCache)
+	}
+	return gr, nil
+}
+
+// duplicateCapture returns the name of a capture group of expr that can take
+// part in a match along with another group of the same name, or "" when group
+// names are only shared between branches of alternations. If expr cannot be
+// parsed by the regexp/syntax package, any name shared by several groups is
+// reported
+func duplicateCapture(expr string, names []string) string {
+	dups, err := duplicateCaptures(expr)
+	if err != nil {
+		seen := map[string]bool{}
+		for _, name := range names {
+			if name != "" && seen[name] {
+				return name
+			}
+			seen[name] = true
+		}
+		return ""
+	}
+	if len(dups) == 0 {
+		return ""
+	}
+	return dups[0]
+}
+
+// duplicateCaptures returns the names of the capture groups of expr that can
+// take part in a match along with another group of the same name, in the order
+// they are found. Names only shared between branches of alternations are left
+// out
+func duplicateCaptures(expr string) ([]string, error) {
+	re, err := resyntax.Parse(expr, resyntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+
+	var dups []string
+	reported := map[string]bool{}
+	report := func(name string) {
+		if !reported[name] {
+			reported[name] = true
+			dups = append(dups, name)
+		}
+	}
+	var walk func(re *resyntax.Regexp) map[string]bool
+	walk = func(re *resyntax.Regexp) map[string]bool {
+		ret := map[string]bool{}
+		for _, sub := range re.Sub {
+			for name := range walk(sub) {
+				if ret[name] && re.Op != resyntax.OpAlternate {
+					report(name)
+				}
+				ret[name] = true
+			}
+		}
+		if re.Op == resyntax.OpCapture && re.Name != "" {
+			if ret[re.Name] {
+				report(re.Name)
+			}
+			ret[re.Name] = true
+		}
+		return ret
+	}
+	walk(re)
+	return dups, nil
 }
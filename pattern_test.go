@@ -1,8 +1,16 @@
 package grok
 
 import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net"
 	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCopyDefalutPatterns(t *testing.T) {
@@ -78,6 +86,14 @@ func TestDenormalizePatternsFromMap(t *testing.T) {
 			wantValid:   []string{},
 			wantInvalid: []string{"A", "B"},
 		},
+		{
+			name: "self reference",
+			patterns: map[string]string{
+				"SELF": `x%{SELF}`,
+			},
+			wantValid:   []string{},
+			wantInvalid: []string{"SELF"},
+		},
 	}
 	
 	for _, tt := range tests {
@@ -681,3 +697,3305 @@ func TestCompilePattern2(t *testing.T) {
 		t.Errorf("address = %q, want %q", address, "192.168.1.1")
 	}
 }
+
+func TestWithDecimalComma(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern("%{NOTSPACE:ratio:float} %{NOTSPACE:count:int}", storage, WithDecimalComma())
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	result, err := gr.RunWithTypeInfo("3,14 42", false)
+	if err != nil {
+		t.Fatalf("RunWithTypeInfo failed: %v", err)
+	}
+
+	ratio, _ := gr.GetValAnyByName("ratio", result)
+	if ratio != 3.14 {
+		t.Errorf("ratio = %v, want 3.14", ratio)
+	}
+	count, _ := gr.GetValAnyByName("count", result)
+	if count != int64(42) {
+		t.Errorf("count = %v, want 42", count)
+	}
+
+	// Without the option the comma is not a decimal separator
+	gr, _ = CompilePattern("%{NOTSPACE:ratio:float}", storage)
+	result, _ = gr.RunWithTypeInfo("3,14", false)
+	if ratio, _ := gr.GetValAnyByName("ratio", result); ratio == 3.14 {
+		t.Errorf("ratio = %v, want conversion failure without WithDecimalComma", ratio)
+	}
+}
+
+func TestGrokRegexpRunFields(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern("%{IP:server} %{NUMBER:port} %{WORD:status}", storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	if i, ok := gr.FieldIndex("status"); !ok || gr.MatchNames()[i] != "status" {
+		t.Errorf("FieldIndex(status) = %d, %v", i, ok)
+	}
+	if _, ok := gr.FieldIndex("missing"); ok {
+		t.Error("FieldIndex(missing) should not be found")
+	}
+
+	result, err := gr.RunFields("192.168.1.1 8080 active", false, "server", "status", "missing")
+	if err != nil {
+		t.Fatalf("RunFields failed: %v", err)
+	}
+	if len(result) != 2 {
+		t.Errorf("Expected 2 fields, got %d: %v", len(result), result)
+	}
+	if result["server"] != "192.168.1.1" {
+		t.Errorf("server = %q, want %q", result["server"], "192.168.1.1")
+	}
+	if result["status"] != "active" {
+		t.Errorf("status = %q, want %q", result["status"], "active")
+	}
+
+	if _, err := gr.RunFields("no match here", false, "server"); err != ErrMismatch {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+}
+
+func TestFindPatternNames(t *testing.T) {
+	names := FindPatternNames("SYSLOG*")
+	if len(names) == 0 {
+		t.Fatal("Expected SYSLOG* to match default patterns")
+	}
+	for i, name := range names {
+		if !strings.HasPrefix(name, "SYSLOG") {
+			t.Errorf("%q does not match SYSLOG*", name)
+		}
+		if i > 0 && names[i-1] > name {
+			t.Errorf("names are not sorted: %v", names)
+		}
+	}
+
+	found := false
+	for _, name := range FindPatternNames("*APACHE*") {
+		if name == "COMMONAPACHELOG" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected *APACHE* to match COMMONAPACHELOG")
+	}
+
+	if names := FindPatternNames("NOPE_*"); len(names) != 0 {
+		t.Errorf("Expected no match, got %v", names)
+	}
+	if names := FindPatternNames("[-"); names != nil {
+		t.Errorf("Expected nil for malformed glob, got %v", names)
+	}
+}
+
+func TestDenormalizePatternsFromMapWithExpandDepth(t *testing.T) {
+	m := map[string]string{
+		"NESTED": `\[(?:[^\[\]]|%{NESTED})*\]`,
+	}
+
+	_, invalid := DenormalizePatternsFromMap(m)
+	if _, ok := invalid["NESTED"]; !ok {
+		t.Fatal("Expected NESTED to be rejected without an expansion depth")
+	}
+
+	valid, invalid := DenormalizePatternsFromMapWithOptions(m, nil, WithExpandDepth(2))
+	if len(invalid) != 0 {
+		t.Fatalf("Unexpected invalid patterns: %v", invalid)
+	}
+
+	gr, err := CompilePattern(`^%{NESTED:list}$`, PatternStorage{valid})
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	tests := []struct {
+		text        string
+		shouldMatch bool
+	}{
+		{"[a]", true},
+		{"[a[b]]", true},
+		{"[a[b[c]]]", true},
+		{"[a[b[c[d]]]]", false},
+	}
+	for _, tt := range tests {
+		_, err := gr.Run(tt.text, false)
+		if (err == nil) != tt.shouldMatch {
+			t.Errorf("Run(%q) err = %v, want match %v", tt.text, err, tt.shouldMatch)
+		}
+	}
+}
+
+func TestDenormalizePatternsFromMapWithExpandDepthIndirect(t *testing.T) {
+	m := map[string]string{
+		"A": `a%{B}?`,
+		"B": `b%{A}?`,
+	}
+
+	valid, invalid := DenormalizePatternsFromMapWithOptions(m, nil, WithExpandDepth(1))
+	if len(invalid) != 0 {
+		t.Fatalf("Unexpected invalid patterns: %v", invalid)
+	}
+
+	re := regexp.MustCompile("^(?:" + valid["A"].Denormalized() + ")$")
+	for _, text := range []string{"a", "ab", "aba", "abab"} {
+		if !re.MatchString(text) {
+			t.Errorf("A should match %q", text)
+		}
+	}
+	if re.MatchString("ababa") {
+		t.Error("A should not match past the expansion depth")
+	}
+}
+
+func TestGrokRegexpMatch(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern("%{IP:server} %{NUMBER:port}", storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	if !gr.Match("192.168.1.1 8080") {
+		t.Error("Expected a match")
+	}
+	if gr.Match("localhost port") {
+		t.Error("Expected no match")
+	}
+	if (&GrokRegexp{}).Match("192.168.1.1 8080") {
+		t.Error("A GrokRegexp that is not compiled should never match")
+	}
+}
+
+func TestDenormalizePatternWithStrictTypes(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	denormalized["LOWER"] = &GrokPattern{pattern: `[a-z]+`, denormalized: `[a-z]+`}
+	storage := PatternStorage{denormalized}
+
+	tests := []struct {
+		name         string
+		pattern      string
+		wantWarnings int
+	}{
+		{"int over letters", "%{LOWER:x:int}", 1},
+		{"float over month names", "%{MONTH:m:float}", 1},
+		{"int over number", "%{NUMBER:n:int}", 0},
+		{"int over word", "%{WORD:w:int}", 0},
+		{"string over letters", "%{LOWER:x:string}", 0},
+		{"untyped", "%{LOWER:x}", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gp, err := DenormalizePatternWithOptions(tt.pattern, storage, WithStrictTypes())
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(gp.Warnings()) != tt.wantWarnings {
+				t.Errorf("Expected %d warnings, got %v", tt.wantWarnings, gp.Warnings())
+			}
+
+			// Without strict mode no warning is collected
+			gp, _ = DenormalizePattern(tt.pattern, storage)
+			if len(gp.Warnings()) != 0 {
+				t.Errorf("Expected no warnings without strict mode, got %v", gp.Warnings())
+			}
+		})
+	}
+}
+
+func TestGrokRegexpRunMap(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern("%{IP:server} %{NUMBER:port:int} %{WORD:status}", storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	m, err := gr.RunMap("192.168.1.1 8080 active", false)
+	if err != nil {
+		t.Fatalf("RunMap failed: %v", err)
+	}
+	if m["server"] != "192.168.1.1" || m["port"] != "8080" || m["status"] != "active" {
+		t.Errorf("Unexpected RunMap result: %v", m)
+	}
+
+	typed, err := gr.RunMapWithTypeInfo("192.168.1.1 8080 active", false)
+	if err != nil {
+		t.Fatalf("RunMapWithTypeInfo failed: %v", err)
+	}
+	if typed["port"] != int64(8080) {
+		t.Errorf("port = %#v, want int64(8080)", typed["port"])
+	}
+
+	j, err := gr.RunJSON("192.168.1.1 8080 active", false)
+	if err != nil {
+		t.Fatalf("RunJSON failed: %v", err)
+	}
+	if string(j) != `{"port":8080,"server":"192.168.1.1","status":"active"}` {
+		t.Errorf("Unexpected RunJSON result: %s", j)
+	}
+
+	if _, err := gr.RunMap("no match", false); err != ErrMismatch {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+}
+
+func TestGrokRegexpRename(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern("%{IP:server} %{NUMBER:port:int}", storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	gr.Rename("server", "host.ip")
+
+	m, err := gr.RunMap("192.168.1.1 8080", false)
+	if err != nil {
+		t.Fatalf("RunMap failed: %v", err)
+	}
+	if _, ok := m["server"]; ok {
+		t.Error("server should have been renamed")
+	}
+	if m["host.ip"] != "192.168.1.1" {
+		t.Errorf("host.ip = %q, want %q", m["host.ip"], "192.168.1.1")
+	}
+
+	j, _ := gr.RunJSON("192.168.1.1 8080", false)
+	if string(j) != `{"host.ip":"192.168.1.1","port":8080}` {
+		t.Errorf("Unexpected RunJSON result: %s", j)
+	}
+
+	// Lookups by capture name are unaffected
+	result, _ := gr.Run("192.168.1.1 8080", false)
+	if v, ok := gr.GetValByName("server", result); !ok || v != "192.168.1.1" {
+		t.Errorf("GetValByName(server) = %q, %v", v, ok)
+	}
+}
+
+func TestDiffPatterns(t *testing.T) {
+	a := CopyDefalutPatterns()
+	b := CopyDefalutPatterns()
+	delete(b, "UUID")
+	delete(b, "MAC")
+	b["WORD"] = `\b[a-z]+\b`
+	b["MYPATTERN"] = `%{WORD}-%{NUMBER}`
+
+	added, removed, changed := DiffPatterns(a, b)
+	if strings.Join(added, ",") != "MYPATTERN" {
+		t.Errorf("added = %v, want [MYPATTERN]", added)
+	}
+	if strings.Join(removed, ",") != "MAC,UUID" {
+		t.Errorf("removed = %v, want [MAC UUID]", removed)
+	}
+	if strings.Join(changed, ",") != "WORD" {
+		t.Errorf("changed = %v, want [WORD]", changed)
+	}
+
+	added, removed, changed = DiffPatterns(a, CopyDefalutPatterns())
+	if len(added)+len(removed)+len(changed) != 0 {
+		t.Errorf("Expected no difference, got %v %v %v", added, removed, changed)
+	}
+}
+
+func TestWithDashAsEmpty(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	line := `127.0.0.1 - - [23/Apr/2014:22:58:32 +0200] "GET /index.php HTTP/1.1" 404 207 -`
+
+	tests := []struct {
+		name     string
+		opts     []CompileOption
+		ident    string
+		auth     string
+		referrer string
+	}{
+		{"disabled", nil, "-", "-", "-"},
+		{"global", []CompileOption{WithDashAsEmpty()}, "", "", ""},
+		{"selected fields", []CompileOption{WithDashAsEmpty("ident", "auth")}, "", "", "-"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gr, err := CompilePattern("%{COMMONAPACHELOG} %{NOTSPACE:referrer}", storage, tt.opts...)
+			if err != nil {
+				t.Fatalf("Failed to compile pattern: %v", err)
+			}
+
+			m, err := gr.RunMap(line, false)
+			if err != nil {
+				t.Fatalf("RunMap failed: %v", err)
+			}
+			if m["ident"] != tt.ident {
+				t.Errorf("ident = %q, want %q", m["ident"], tt.ident)
+			}
+			if m["auth"] != tt.auth {
+				t.Errorf("auth = %q, want %q", m["auth"], tt.auth)
+			}
+			if m["referrer"] != tt.referrer {
+				t.Errorf("referrer = %q, want %q", m["referrer"], tt.referrer)
+			}
+			if m["clientip"] != "127.0.0.1" {
+				t.Errorf("clientip = %q, want %q", m["clientip"], "127.0.0.1")
+			}
+		})
+	}
+}
+
+func TestCompilePatternErrorContext(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	_, err := CompilePattern("%{IP:server} (?<broken", storage)
+	if err == nil {
+		t.Fatal("Expected compile error")
+	}
+
+	msg := err.Error()
+	if !strings.Contains(msg, "%{IP:server} (?<broken") {
+		t.Errorf("Error should mention the grok pattern: %s", msg)
+	}
+	if !strings.Contains(msg, "near `") {
+		t.Errorf("Error should include an excerpt of the expression: %s", msg)
+	}
+
+	var synErr *syntax.Error
+	if !errors.As(err, &synErr) {
+		t.Errorf("Error should wrap the regexp error, got %T", err)
+	}
+}
+
+func TestWithDelimiters(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gp, err := DenormalizePatternWithOptions("<<IP:server>> <<NUMBER:port:int>> %{WORD}", storage, WithDelimiters("<<", ">>"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	gr, err := CompilePattern2(gp, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	m, err := gr.RunMap("192.168.1.1 8080 %{WORD}", false)
+	if err != nil {
+		t.Fatalf("RunMap failed: %v", err)
+	}
+	if m["server"] != "192.168.1.1" || m["port"] != "8080" {
+		t.Errorf("Unexpected RunMap result: %v", m)
+	}
+
+	valid, invalid := DenormalizePatternsFromMapWithOptions(map[string]string{
+		"HOSTPORT2": "<<IPORHOST:host>>:<<POSINT:port>>",
+		"ENDPOINT":  "<<WORD:proto>>://<<HOSTPORT2>>",
+		"BROKEN":    "<<DOESNOTEXIST>>",
+	}, []map[string]*GrokPattern{denormalized}, WithDelimiters("<<", ">>"))
+	if _, ok := valid["ENDPOINT"]; !ok {
+		t.Fatalf("Expected ENDPOINT to be valid, invalid: %v", invalid)
+	}
+	if msg := invalid["BROKEN"]; msg != "no pattern found for <<DOESNOTEXIST>>" {
+		t.Errorf("Unexpected error for BROKEN: %q", msg)
+	}
+
+	re := regexp.MustCompile(valid["ENDPOINT"].Denormalized())
+	if !re.MatchString("tcp://example.com:443") {
+		t.Error("ENDPOINT should match tcp://example.com:443")
+	}
+}
+
+func TestWithMaxDenormalizedLen(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	_, err := CompilePattern("%{COMBINEDAPACHELOG}", storage, WithMaxDenormalizedLen(100))
+	if !errors.Is(err, ErrPatternTooLarge) {
+		t.Fatalf("Expected ErrPatternTooLarge, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "%{COMBINEDAPACHELOG}") {
+		t.Errorf("Error should mention the pattern: %v", err)
+	}
+
+	gp, _ := DenormalizePattern("%{IPV6:ip}", storage)
+	if _, err := CompilePattern2(gp, storage, WithMaxDenormalizedLen(100)); !errors.Is(err, ErrPatternTooLarge) {
+		t.Errorf("Expected ErrPatternTooLarge from CompilePattern2, got %v", err)
+	}
+
+	if _, err := CompilePattern("%{WORD:w}", storage, WithMaxDenormalizedLen(100)); err != nil {
+		t.Errorf("Unexpected error for a short pattern: %v", err)
+	}
+}
+
+func TestSameDenormalized(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"%{IP:client}", "%{IP:server}", true},
+		{"%{IP:client}", "%{IP}", true},
+		{"%{USER:u}", "%{USERNAME:name}", true},
+		{"%{NUMBER:n} %{WORD}", "(?:%{NUMBER}) %{WORD:w}", true},
+		{"%{IP:client}", "%{HOSTNAME:client}", false},
+		{"%{NUMBER:n}", "%{INT:n}", false},
+	}
+
+	for _, tt := range tests {
+		same, err := SameDenormalized(tt.a, tt.b, storage)
+		if err != nil {
+			t.Fatalf("SameDenormalized(%q, %q) failed: %v", tt.a, tt.b, err)
+		}
+		if same != tt.want {
+			t.Errorf("SameDenormalized(%q, %q) = %v, want %v", tt.a, tt.b, same, tt.want)
+		}
+	}
+
+	if _, err := SameDenormalized("%{IP}", "%{DOESNOTEXIST}", storage); err == nil {
+		t.Error("Expected error for an unknown pattern")
+	}
+}
+
+func TestGrokRegexpReset(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern("%{IP:server} %{USER:auth}", storage, WithDashAsEmpty())
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	gr.Rename("server", "host")
+	gr.Reset()
+
+	m, err := gr.RunMap("192.168.1.1 -", false)
+	if err != nil {
+		t.Fatalf("RunMap failed: %v", err)
+	}
+	if m["server"] != "192.168.1.1" {
+		t.Errorf("Reset should discard renames, got %v", m)
+	}
+	if m["auth"] != "" {
+		t.Errorf("Reset should keep compile options, auth = %q", m["auth"])
+	}
+}
+
+func TestGrokRegexpRunAllWithPositions(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{WORD:key}=%{NUMBER:value}(?:/%{WORD:unit})?`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	content := "cpu=42 mem=512/mb disk=7"
+	all, err := gr.RunAllWithPositions(content)
+	if err != nil {
+		t.Fatalf("RunAllWithPositions failed: %v", err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("Expected 3 matches, got %d: %v", len(all), all)
+	}
+
+	expected := []map[string]string{
+		{"key": "cpu", "value": "42"},
+		{"key": "mem", "value": "512", "unit": "mb"},
+		{"key": "disk", "value": "7"},
+	}
+	for i, want := range expected {
+		if len(all[i]) != len(want) {
+			t.Errorf("match %d: got fields %v, want %v", i, all[i], want)
+		}
+		for name, value := range want {
+			pos, ok := all[i][name]
+			if !ok {
+				t.Errorf("match %d: missing field %s", i, name)
+				continue
+			}
+			if got := content[pos[0]:pos[1]]; got != value {
+				t.Errorf("match %d: %s = %q, want %q", i, name, got, value)
+			}
+		}
+	}
+
+	if _, err := gr.RunAllWithPositions("nothing to see"); err != ErrMismatch {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+
+	gr.Rename("key", "metric")
+	all, err = gr.RunAllWithPositions(content)
+	if err != nil {
+		t.Fatalf("RunAllWithPositions failed: %v", err)
+	}
+	if _, ok := all[0]["key"]; ok {
+		t.Error("key should have been renamed")
+	}
+	if pos, ok := all[0]["metric"]; !ok || content[pos[0]:pos[1]] != "cpu" {
+		t.Errorf("Expected metric at the position of cpu, got %v", all[0])
+	}
+}
+
+func TestCompilePatternVerbose(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, warnings, err := CompilePatternVerbose("%{WORD:a:str} %{WORD:b:string} %{MONTH:c:int} %{NUMBER:d:int} %{WORD:e}", storage)
+	if err != nil {
+		t.Fatalf("CompilePatternVerbose failed: %v", err)
+	}
+	if !gr.Match("x y Jan 1 z") {
+		t.Error("Compiled pattern should match")
+	}
+
+	fields := []string{}
+	for _, w := range warnings {
+		fields = append(fields, w.Field)
+	}
+	if strings.Join(fields, ",") != "a,b,c" {
+		t.Errorf("Expected warnings for a, b and c, got %v", warnings)
+	}
+
+	if _, _, err := CompilePatternVerbose("%{DOESNOTEXIST}", storage); err == nil {
+		t.Error("Expected error for an unknown pattern")
+	}
+}
+
+func TestDurationType(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern("%{WORD:op} took %{NOTSPACE:latency:duration}", storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	tests := []struct {
+		text string
+		want time.Duration
+		ok   bool
+	}{
+		{"get took 1.2s", 1200 * time.Millisecond, true},
+		{"put took 350ms", 350 * time.Millisecond, true},
+		{"del took 1h2m", time.Hour + 2*time.Minute, true},
+		{"del took forever", 0, false},
+	}
+
+	for _, tt := range tests {
+		result, err := gr.Run(tt.text, false)
+		if err != nil {
+			t.Fatalf("Run(%q) failed: %v", tt.text, err)
+		}
+		v, ok := gr.GetValCastByName("latency", result)
+		if ok != tt.ok {
+			t.Errorf("%q: ok = %v, want %v", tt.text, ok, tt.ok)
+			continue
+		}
+		if ok && v != tt.want {
+			t.Errorf("%q: latency = %v, want %v", tt.text, v, tt.want)
+		}
+	}
+
+	typed, err := gr.RunWithTypeInfo("get took 1.2s", false)
+	if err != nil {
+		t.Fatalf("RunWithTypeInfo failed: %v", err)
+	}
+	if v, _ := gr.GetValAnyByName("latency", typed); v != 1200*time.Millisecond {
+		t.Errorf("latency = %#v, want a time.Duration of 1.2s", v)
+	}
+}
+
+func TestCompileFromMap(t *testing.T) {
+	gr, err := CompileFromMap("%{IRCMSG}", map[string]string{
+		"IRCUSER": `\A@(\w+)`,
+		"IRCBODY": `.*`,
+		"IRCMSG":  `%{IRCUSER:user} .* : %{IRCBODY:message}`,
+		"WORD":    `[a-z]+`,
+	})
+	if err != nil {
+		t.Fatalf("CompileFromMap failed: %v", err)
+	}
+	m, err := gr.RunMap("@vjeantet said : Hello !", false)
+	if err != nil {
+		t.Fatalf("RunMap failed: %v", err)
+	}
+	if m["user"] != "@vjeantet" || m["message"] != "Hello !" {
+		t.Errorf("Unexpected RunMap result: %v", m)
+	}
+
+	// Definitions take precedence over the default patterns
+	gr, err = CompileFromMap("^%{WORD:w}$", map[string]string{"WORD": `[a-z]+`})
+	if err != nil {
+		t.Fatalf("CompileFromMap failed: %v", err)
+	}
+	if gr.Match("ABC") {
+		t.Error("WORD should be overridden by the definitions")
+	}
+
+	// Default patterns referencing an overridden pattern use the definition
+	gr, err = CompileFromMap("^%{NUMBER:n}$", map[string]string{"BASE10NUM": `[0-9]+`})
+	if err != nil {
+		t.Fatalf("CompileFromMap failed: %v", err)
+	}
+	if gr.Match("1.5") || !gr.Match("15") {
+		t.Error("NUMBER should use the overridden BASE10NUM")
+	}
+	gr, err = CompileFromMap("^%{HTTPDATE:ts}$", map[string]string{"INT": `[+-][0-9]{4}`})
+	if err != nil {
+		t.Fatalf("CompileFromMap failed: %v", err)
+	}
+	if gr.Match("10/Oct/2000:13:55:36 700") || !gr.Match("10/Oct/2000:13:55:36 -0700") {
+		t.Error("HTTPDATE should use the overridden INT")
+	}
+
+	_, err = CompileFromMap("%{A}", map[string]string{
+		"A": `%{MISSING}`,
+		"B": `%{ALSOMISSING}`,
+	})
+	if err == nil {
+		t.Fatal("Expected error for invalid definitions")
+	}
+	if !strings.Contains(err.Error(), "A: ") || !strings.Contains(err.Error(), "B: ") {
+		t.Errorf("Error should list every invalid definition: %v", err)
+	}
+}
+
+func TestGrokRegexpAddFieldPattern(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern("%{EMAILADDRESS:email} %{WORD:status}(?: %{WORD:note})?", storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	gr.AddFieldPattern("email", regexp.MustCompile(`@example\.com$`))
+	gr.AddFieldPattern("note", regexp.MustCompile(`^urgent$`))
+
+	if _, err := gr.Run("john@example.com active", false); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	_, err = gr.Run("john@other.org active", false)
+	if !errors.Is(err, ErrFieldConstraint) {
+		t.Errorf("Expected ErrFieldConstraint, got %v", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "email") {
+		t.Errorf("Error should name the field: %v", err)
+	}
+
+	if _, err := gr.RunMap("john@example.com active later", false); !errors.Is(err, ErrFieldConstraint) {
+		t.Errorf("Expected ErrFieldConstraint from RunMap, got %v", err)
+	}
+	if _, err := gr.RunFields("john@other.org active", false, "status"); err != nil {
+		t.Errorf("RunFields should only check the requested fields, got %v", err)
+	}
+	if gr.Match("john@other.org active") || gr.Match("john@example.com active later") {
+		t.Error("Match should check field constraints")
+	}
+	if !gr.Match("john@example.com active") || !gr.Match("john@example.com active urgent") {
+		t.Error("Match should accept values satisfying the constraints")
+	}
+
+	gr.Reset()
+	if _, err := gr.Run("john@other.org active", false); err != nil {
+		t.Errorf("Reset should discard field constraints, got %v", err)
+	}
+	if !gr.Match("john@other.org active") {
+		t.Error("Reset should discard field constraints of Match")
+	}
+}
+
+func TestGrokPatternPrettyDenormalized(t *testing.T) {
+	storage := PatternStorage{map[string]*GrokPattern{
+		"NUM":  {pattern: `\d+`, denormalized: `\d+`},
+		"PAIR": {pattern: `%{NUM:a}-%{NUM:b}`, denormalized: `(?P<a>\d+)-(?P<b>\d+)`},
+	}}
+
+	gp, err := DenormalizePattern("x=(?:%{PAIR:pair}|[()])\t%{NUM}", storage)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := strings.Join([]string{
+		`x=(?:`,
+		`(?P<pair>`,
+		`  (?P<a>`,
+		`    \d+`,
+		`  )`,
+		`  -`,
+		`  (?P<b>`,
+		`    \d+`,
+		`  )`,
+		`)`,
+		`|[()])\x{9}(\d+)`,
+	}, "\n")
+	if got := gp.PrettyDenormalized(); got != expected {
+		t.Errorf("PrettyDenormalized() =\n%s\nwant\n%s", got, expected)
+	}
+}
+
+func TestGrokRegexpTrimLineEndings(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	line := `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326` + "\r\n"
+
+	plain, err := CompilePattern(`^%{COMMONAPACHELOG}$`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if _, err := plain.Run(line, false); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch without WithTrimLineEndings, got %v", err)
+	}
+
+	gr, err := CompilePattern(`^%{COMMONAPACHELOG}$`, storage, WithTrimLineEndings())
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if !gr.Match(line) {
+		t.Errorf("Expected CRLF terminated line to match")
+	}
+	ret, err := gr.RunMap(line, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ret["bytes"] != "2326" {
+		t.Errorf("Expected bytes 2326, got %q", ret["bytes"])
+	}
+}
+
+func TestGrokRegexpRunWithRemainder(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`^%{IP:client} %{WORD:method} `, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	ret, remainder, err := gr.RunWithRemainder("10.0.0.1 GET /index.html 200", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if v, _ := gr.GetValByName("method", ret); v != "GET" {
+		t.Errorf("Expected method GET, got %q", v)
+	}
+	if remainder != "/index.html 200" {
+		t.Errorf("Expected remainder %q, got %q", "/index.html 200", remainder)
+	}
+
+	rest, err := CompilePattern(`%{URIPATH:path} %{INT:status:int}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	ret, remainder, err = rest.RunWithRemainder(remainder, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if v, _ := rest.GetValByName("path", ret); v != "/index.html" {
+		t.Errorf("Expected path /index.html, got %q", v)
+	}
+	if remainder != "" {
+		t.Errorf("Expected empty remainder, got %q", remainder)
+	}
+
+	if _, _, err := gr.RunWithRemainder("not a log line", false); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+}
+
+func TestGrokRegexpSortedFieldNames(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{WORD:verb} %{IP:client} %{INT:bytes}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	sorted := gr.SortedFieldNames()
+	if strings.Join(sorted, ",") != "bytes,client,verb" {
+		t.Errorf("Expected sorted names bytes,client,verb, got %v", sorted)
+	}
+	if names := gr.MatchNames(); strings.Join(names, ",") != "verb,client,bytes" {
+		t.Errorf("Expected MatchNames to keep pattern order, got %v", names)
+	}
+}
+
+func TestCompileAll(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	compiled, errs := CompileAll(map[string]string{
+		"access":  `%{IP:client} %{WORD:method}`,
+		"missing": `%{NOSUCHPATTERN:x}`,
+		"syntax":  `%{WORD:w} (`,
+		"status":  `%{INT:status:int}`,
+	}, storage)
+
+	if len(compiled) != 2 || compiled["access"] == nil || compiled["status"] == nil {
+		t.Errorf("Expected access and status to compile, got %v", compiled)
+	}
+	if len(errs) != 2 || errs["missing"] == nil || errs["syntax"] == nil {
+		t.Errorf("Expected errors for missing and syntax, got %v", errs)
+	}
+	if gr := compiled["access"]; gr != nil && !gr.Match("10.0.0.1 GET") {
+		t.Errorf("Expected compiled access pattern to match")
+	}
+}
+
+func TestGrokRegexpIntBases(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{DATA:code:int}$`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	tests := []struct {
+		input    string
+		expected int64
+		ok       bool
+	}{
+		{"0x1F4", 500, true},
+		{"0o17", 15, true},
+		{"0b101", 5, true},
+		{"-0x10", -16, true},
+		{"500", 500, true},
+		{"010", 10, true},
+		{"0xZZ", 0, false},
+		{"abc", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			ret, err := gr.Run(tt.input, false)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			v, ok := gr.GetValCastByName("code", ret)
+			if ok != tt.ok {
+				t.Fatalf("Expected ok %v, got %v (value %v)", tt.ok, ok, v)
+			}
+			if ok && v != tt.expected {
+				t.Errorf("Expected %d, got %v", tt.expected, v)
+			}
+		})
+	}
+}
+
+func TestPatternFields(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	names, types, err := PatternFields(`%{IP:client} (?P<verb>\w+) %{INT:status:int} %{NUMBER:took:float}`, storage)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Join(names, ",") != "client,verb,status,took" {
+		t.Errorf("Expected fields client,verb,status,took, got %v", names)
+	}
+	if len(types) != 2 || types["status"] != GTypeInt || types["took"] != GTypeFloat {
+		t.Errorf("Expected status int and took float, got %v", types)
+	}
+
+	gr, err := CompilePattern(`%{IP:client} (?P<verb>\w+) %{INT:status:int} %{NUMBER:took:float}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if strings.Join(gr.MatchNames(), ",") != strings.Join(names, ",") {
+		t.Errorf("Expected PatternFields to agree with MatchNames %v, got %v", gr.MatchNames(), names)
+	}
+
+	if _, _, err := PatternFields(`%{NOSUCHPATTERN:x}`, storage); err == nil {
+		t.Errorf("Expected error for unknown pattern")
+	}
+	if _, _, err := PatternFields(`%{WORD:w} (`, storage); err == nil {
+		t.Errorf("Expected error for invalid expression")
+	}
+}
+
+func TestDenormalizeWithNameSanitizer(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	camelCase := func(name string) string {
+		parts := strings.FieldsFunc(name, func(r rune) bool { return r == '.' || r == '-' || r == '_' })
+		for i := 1; i < len(parts); i++ {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+		return strings.Join(parts, "")
+	}
+
+	gp, err := DenormalizePatternWithOptions(`%{IP:client.ip} %{INT:http-status:int}`, storage, WithNameSanitizer(camelCase))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if gp.TypedVar()["httpStatus"] != GTypeInt {
+		t.Errorf("Expected httpStatus to be typed int, got %v", gp.TypedVar())
+	}
+
+	gr, err := CompilePattern2(gp, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if names := strings.Join(gr.MatchNames(), ","); names != "clientIp,httpStatus" {
+		t.Errorf("Expected fields clientIp,httpStatus, got %s", names)
+	}
+
+	gp, err = DenormalizePattern(`%{IP:client.ip}`, storage)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(gp.Denormalized(), "(?P<client_ip>") {
+		t.Errorf("Expected default sanitizer to produce client_ip, got %s", gp.Denormalized())
+	}
+}
+
+func TestPatternStorageUpdate(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	custom, invalid := DenormalizePatternsFromMap(map[string]string{
+		"LEVEL":  `(?:INFO|WARN)`,
+		"PREFIX": `%{LEVEL:level}:`,
+		"LINE":   `%{PREFIX} %{GREEDYDATA:msg}`,
+		"OTHER":  `%{WORD:word}`,
+	}, denormalized)
+	if len(invalid) != 0 {
+		t.Fatalf("Unexpected invalid patterns: %v", invalid)
+	}
+	storage := PatternStorage{custom, denormalized}
+	other := custom["OTHER"]
+
+	updated, err := storage.Update("LEVEL", `(?:INFO|WARN|ERROR)`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(updated) != 3 {
+		t.Errorf("Expected LEVEL, PREFIX and LINE to be recomputed, got %v", updated)
+	}
+	for _, name := range []string{"LEVEL", "PREFIX", "LINE"} {
+		if !strings.Contains(updated[name], "ERROR") {
+			t.Errorf("Expected %s to include ERROR, got %q", name, updated[name])
+		}
+	}
+	if custom["OTHER"] != other {
+		t.Errorf("Expected OTHER to be left untouched")
+	}
+
+	gr, err := CompilePattern(`%{LINE}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	ret, err := gr.RunMap("ERROR: disk full", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ret["level"] != "ERROR" || ret["msg"] != "disk full" {
+		t.Errorf("Unexpected result: %v", ret)
+	}
+
+	// A failing update leaves the storage unchanged
+	line := custom["LINE"]
+	if _, err := storage.Update("PREFIX", `%{NOSUCHPATTERN}`); err == nil {
+		t.Errorf("Expected error for unknown reference")
+	}
+	if custom["LINE"] != line {
+		t.Errorf("Expected LINE to be left untouched after a failed update")
+	}
+
+	if _, err := storage.Update("NEWONE", `%{INT:n}`); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := storage.GetPattern("NEWONE"); !ok {
+		t.Errorf("Expected NEWONE to be added to the storage")
+	}
+
+	// Dependents written with custom delimiters are recomputed
+	delimited, invalid := DenormalizePatternsFromMapWithOptions(map[string]string{
+		"LEVEL":  `(?:INFO|WARN)`,
+		"PREFIX": `<<LEVEL:level>>:`,
+		"LINE":   `<<PREFIX>> <<GREEDYDATA:msg>>`,
+	}, []map[string]*GrokPattern{denormalized}, WithDelimiters("<<", ">>"))
+	if len(invalid) != 0 {
+		t.Fatalf("Unexpected invalid patterns: %v", invalid)
+	}
+	storage = PatternStorage{delimited, denormalized}
+	updated, err = storage.Update("LEVEL", `(?:INFO|WARN|ERROR)`, WithDelimiters("<<", ">>"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(updated) != 3 || !strings.Contains(updated["LINE"], "ERROR") {
+		t.Errorf("Expected LEVEL, PREFIX and LINE to be recomputed, got %v", updated)
+	}
+}
+
+func TestDenormalizePatternDuplicateCaptureName(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`%{WORD:name} %{INT:name}`, "`name` at offsets 0, 13"},
+		{`(?P<id>\d+)-%{WORD:id}`, "`id` at offsets 0, 12"},
+		{`%{IP:client} %{SYSLOGHOST:client}`, "`client` at offsets 0, 13"},
+		{`%{WORD:a} (?:%{INT:b}|%{WORD:b}) %{WORD:b}`, "`b`"},
+	}
+	for _, tt := range tests {
+		_, err := DenormalizePattern(tt.input, storage)
+		if !errors.Is(err, ErrDuplicateCaptureName) {
+			t.Errorf("Expected ErrDuplicateCaptureName for %s, got %v", tt.input, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("Expected error for %s to contain %q, got %v", tt.input, tt.expected, err)
+		}
+	}
+
+	for _, input := range []string{`(?:%{INT:id}|id-%{WORD:id})`, `%{WORD:a} (?P<b>\d+)`} {
+		if _, err := DenormalizePattern(input, storage); err != nil {
+			t.Errorf("Unexpected error for %s: %v", input, err)
+		}
+	}
+
+	// A pattern capturing a name of the pattern it references is invalid
+	_, invalid := DenormalizePatternsFromMap(map[string]string{
+		"INNER": `%{WORD:user}@%{WORD:host}`,
+		"OUTER": `%{INNER} %{WORD:host}`,
+		"OTHER": `%{INNER} %{WORD:port}`,
+	}, denormalized)
+	if !strings.Contains(invalid["OUTER"], "duplicate capture name: `host`") {
+		t.Errorf("Expected OUTER to be invalid, got %v", invalid)
+	}
+	if _, ok := invalid["OTHER"]; ok {
+		t.Errorf("Unexpected error for OTHER: %v", invalid["OTHER"])
+	}
+}
+
+func TestDenormalizePatternTypeConflict(t *testing.T) {
+	storage := PatternStorage{map[string]*GrokPattern{
+		"NUM": {pattern: `\d+(?:\.\d+)?`, denormalized: `\d+(?:\.\d+)?`, varbType: map[string]string{}},
+		"DURATION": {
+			pattern:      `%{NUM:value:float}ms`,
+			denormalized: `(?P<value>\d+(?:\.\d+)?)ms`,
+			varbType:     map[string]string{"value": GTypeFloat},
+		},
+	}}
+
+	_, err := DenormalizePattern(`%{DURATION:value:int}`, storage)
+	if err == nil {
+		t.Fatalf("Expected error for conflicting types")
+	}
+	if !strings.Contains(err.Error(), "conflicting data types for `value`") {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+
+	// Types of other fields merge silently
+	for _, input := range []string{`%{DURATION}`, `%{DURATION:took:int}`} {
+		if _, err := DenormalizePattern(input, storage); err != nil {
+			t.Errorf("Unexpected error for %s: %v", input, err)
+		}
+	}
+
+	// Agreeing annotations still capture value twice
+	for _, input := range []string{`%{DURATION:value:float}`, `%{DURATION:value}`} {
+		if _, err := DenormalizePattern(input, storage); !errors.Is(err, ErrDuplicateCaptureName) {
+			t.Errorf("Expected ErrDuplicateCaptureName for %s, got %v", input, err)
+		}
+	}
+}
+
+func TestDelimitedField(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	tests := []struct {
+		name     string
+		delim    string
+		expected string
+	}{
+		{"agent", ",", `(?P<agent>[^\,]*)`},
+		{"msg", "\t", `(?P<msg>[^\x{9}]*)`},
+		{"user.name", "]-^", `(?P<user_name>[^\]\-\^]*)`},
+	}
+	for _, tt := range tests {
+		if got := DelimitedField(tt.name, tt.delim); got != tt.expected {
+			t.Errorf("DelimitedField(%q, %q) = %s, want %s", tt.name, tt.delim, got, tt.expected)
+		}
+	}
+
+	gr, err := CompilePattern(`^%{IP:client},`+DelimitedField("agent", ",")+`,`+DelimitedField("msg", "\t"), storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	ret, err := gr.RunMap("10.0.0.1,curl/7.68,GET /\tignored", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ret["agent"] != "curl/7.68" || ret["msg"] != "GET /" {
+		t.Errorf("Unexpected result: %v", ret)
+	}
+}
+
+type recordingObserver struct {
+	denormalized []string
+	compiled     []string
+	errs         []error
+}
+
+func (r *recordingObserver) OnDenormalize(pattern string, dur time.Duration) {
+	r.denormalized = append(r.denormalized, pattern)
+}
+
+func (r *recordingObserver) OnCompile(pattern string, dur time.Duration, err error) {
+	r.compiled = append(r.compiled, pattern)
+	r.errs = append(r.errs, err)
+}
+
+func TestCompileObserver(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	obs := &recordingObserver{}
+	if _, err := CompilePattern(`%{IP:client}`, storage, WithCompileObserver(obs)); err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if len(obs.denormalized) != 1 || obs.denormalized[0] != `%{IP:client}` {
+		t.Errorf("Expected one denormalization of %%{IP:client}, got %v", obs.denormalized)
+	}
+	if len(obs.compiled) != 1 || obs.errs[0] != nil {
+		t.Errorf("Expected one successful compilation, got %v %v", obs.compiled, obs.errs)
+	}
+
+	obs = &recordingObserver{}
+	if _, err := CompilePattern(`%{WORD:w} (`, storage, WithCompileObserver(obs)); err == nil {
+		t.Fatalf("Expected compile error")
+	}
+	if len(obs.errs) != 1 || obs.errs[0] == nil {
+		t.Errorf("Expected the compile error to be observed, got %v", obs.errs)
+	}
+
+	obs = &recordingObserver{}
+	if _, err := CompilePattern(`%{NOSUCHPATTERN}`, storage, WithCompileObserver(obs)); err == nil {
+		t.Fatalf("Expected denormalize error")
+	}
+	if len(obs.denormalized) != 1 || len(obs.compiled) != 0 {
+		t.Errorf("Expected only the denormalization to be observed, got %v %v", obs.denormalized, obs.compiled)
+	}
+}
+
+func TestGrokRegexpAnchors(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	tests := []struct {
+		name    string
+		opts    []CompileOption
+		matches map[string]bool
+	}{
+		{
+			name:    "no anchor",
+			matches: map[string]bool{"GET 200": true, "x GET 200": true, "GET 200 x": true},
+		},
+		{
+			name:    "start",
+			opts:    []CompileOption{WithAnchorStart()},
+			matches: map[string]bool{"GET 200": true, "x GET 200": false, "GET 200 x": true},
+		},
+		{
+			name:    "end",
+			opts:    []CompileOption{WithAnchorEnd()},
+			matches: map[string]bool{"GET 200": true, "x GET 200": true, "GET 200 x": false},
+		},
+		{
+			name:    "both",
+			opts:    []CompileOption{WithAnchorStart(), WithAnchorEnd()},
+			matches: map[string]bool{"GET 200": true, "x GET 200": false, "GET 200 x": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gr, err := CompilePattern(`%{WORD:method} %{INT:status}`, storage, tt.opts...)
+			if err != nil {
+				t.Fatalf("Failed to compile pattern: %v", err)
+			}
+			for content, expected := range tt.matches {
+				if got := gr.Match(content); got != expected {
+					t.Errorf("Match(%q) = %v, want %v", content, got, expected)
+				}
+			}
+		})
+	}
+
+	gr, err := CompilePattern(`%{WORD:method} %{INT:status}`, storage, WithAnchorStart())
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	ret, remainder, err := gr.RunWithRemainder("GET 200 /index.html", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if v, _ := gr.GetValByName("status", ret); v != "200" {
+		t.Errorf("Expected status 200, got %q", v)
+	}
+	if remainder != " /index.html" {
+		t.Errorf("Expected remainder %q, got %q", " /index.html", remainder)
+	}
+}
+
+func TestGrokRegexpRunAllMap(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{WORD:key}=(?:%{INT:num}|%{WORD:word})`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	ret, err := gr.RunAllMap("a=1 b=two c=3", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []map[string]string{
+		{"key": "a", "num": "1", "word": ""},
+		{"key": "b", "num": "", "word": "two"},
+		{"key": "c", "num": "3", "word": ""},
+	}
+	if len(ret) != len(expected) {
+		t.Fatalf("Expected %d matches, got %d: %v", len(expected), len(ret), ret)
+	}
+	for i := range expected {
+		if len(ret[i]) != len(expected[i]) {
+			t.Errorf("Match %d: expected keys %v, got %v", i, expected[i], ret[i])
+		}
+		for k, v := range expected[i] {
+			if got, ok := ret[i][k]; !ok || got != v {
+				t.Errorf("Match %d: expected %s=%q, got %q", i, k, v, got)
+			}
+		}
+	}
+
+	if _, err := gr.RunAllMap("no pairs here", false); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+}
+
+func TestPatternStorageFreeze(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	custom, _ := DenormalizePatternsFromMap(map[string]string{"WORD": `[a-z]+`}, denormalized)
+	storage := PatternStorage{custom, denormalized}
+
+	frozen := storage.Freeze()
+
+	// The first map shadows the following ones as in PatternStorage
+	if gp, ok := frozen.GetPattern("WORD"); !ok || gp.Denormalized() != `[a-z]+` {
+		t.Errorf("Expected WORD from the first map, got %v", gp)
+	}
+	if _, ok := frozen.GetPattern("IP"); !ok {
+		t.Errorf("Expected IP to be found")
+	}
+
+	// Changes to the original storage are not visible
+	storage.SetPattern("ADDED", &GrokPattern{pattern: `x`, denormalized: `x`})
+	if _, ok := frozen.GetPattern("ADDED"); ok {
+		t.Errorf("Expected the frozen storage not to see later changes")
+	}
+
+	gr, err := CompilePattern(`%{WORD:w} %{IP:ip}`, frozen)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if !gr.Match("abc 10.0.0.1") {
+		t.Errorf("Expected match")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected SetPattern on a frozen storage to panic")
+		}
+	}()
+	frozen.SetPattern("NEW", &GrokPattern{})
+}
+
+func TestGrokRegexpRunPairs(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{WORD:verb} %{IP:client} %{INT:bytes}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	gr.Rename("client", "ip")
+
+	ret, err := gr.RunPairs("GET 10.0.0.1 512", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []Pair{{"verb", "GET"}, {"ip", "10.0.0.1"}, {"bytes", "512"}}
+	if len(ret) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, ret)
+	}
+	for i := range expected {
+		if ret[i] != expected[i] {
+			t.Errorf("Pair %d: expected %v, got %v", i, expected[i], ret[i])
+		}
+	}
+
+	if _, err := gr.RunPairs("nothing", false); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+}
+
+func TestGrokRegexpRunTypedPairs(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{WORD:verb} %{IP:client} %{INT:bytes:int} %{NUMBER:took:float} %{WORD:ok:bool}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	gr.Rename("client", "ip")
+
+	ret, err := gr.RunTypedPairs("GET 10.0.0.1 512 0.25 true", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []TypedPair{
+		{"verb", "GET", ""},
+		{"ip", "10.0.0.1", ""},
+		{"bytes", int64(512), GTypeInt},
+		{"took", 0.25, GTypeFloat},
+		{"ok", true, GTypeBool},
+	}
+	if len(ret) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, ret)
+	}
+	for i := range expected {
+		if ret[i] != expected[i] {
+			t.Errorf("Pair %d: expected %v, got %v", i, expected[i], ret[i])
+		}
+	}
+
+	ret, err = gr.RunTypedPairs("GET 10.0.0.1 512 0.25 maybe", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ret[4].Value != false || ret[4].Type != GTypeBool {
+		t.Errorf("Expected false for a failed bool conversion, got %v", ret[4])
+	}
+
+	if _, err := gr.RunTypedPairs("nothing", false); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+}
+
+func TestDenormalizePatternsFromMapSelfReference(t *testing.T) {
+	_, invalid := DenormalizePatternsFromMap(map[string]string{
+		"SELF":  `x%{SELF}`,
+		"OUTER": `%{SELF}`,
+		"A":     `%{B}`,
+		"B":     `%{A}`,
+	})
+
+	for _, name := range []string{"SELF", "OUTER"} {
+		if invalid[name] != "pattern SELF references itself" {
+			t.Errorf("Unexpected error for %s: %q", name, invalid[name])
+		}
+	}
+	if !strings.HasPrefix(invalid["A"], "circular dependency: pattern ") {
+		t.Errorf("Unexpected error for A: %q", invalid["A"])
+	}
+}
+
+func TestGrokRegexpSetMultiValue(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`tags=%{NOTSPACE:tags} ports=%{NOTSPACE:ports:int} empty=%{DATA:empty}$`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	gr.SetMultiValue("tags", ",")
+	gr.SetMultiValue("ports", ",")
+	gr.SetMultiValue("empty", ",")
+
+	line := "tags=web,db,cache ports=80,443 empty="
+	ret, err := gr.RunMapWithTypeInfo(line, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tags, ok := ret["tags"].([]string)
+	if !ok || strings.Join(tags, "|") != "web|db|cache" {
+		t.Errorf("Expected tags [web db cache], got %#v", ret["tags"])
+	}
+	ports, ok := ret["ports"].([]interface{})
+	if !ok || len(ports) != 2 || ports[0] != int64(80) || ports[1] != int64(443) {
+		t.Errorf("Expected ports [80 443], got %#v", ret["ports"])
+	}
+	if empty, ok := ret["empty"].([]string); !ok || len(empty) != 0 {
+		t.Errorf("Expected empty slice, got %#v", ret["empty"])
+	}
+
+	js, err := gr.RunJSON(line, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(string(js), `"tags":["web","db","cache"]`) || !strings.Contains(string(js), `"ports":[80,443]`) {
+		t.Errorf("Unexpected JSON: %s", js)
+	}
+
+	// String outputs keep the whole value
+	m, err := gr.RunMap(line, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if m["tags"] != "web,db,cache" {
+		t.Errorf("Expected RunMap to keep the whole value, got %q", m["tags"])
+	}
+
+	gr.Reset()
+	ret, err = gr.RunMapWithTypeInfo(line, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ret["tags"] != "web,db,cache" {
+		t.Errorf("Expected Reset to clear multi-value fields, got %#v", ret["tags"])
+	}
+}
+
+// largeOverlay returns n patterns layered over the default patterns, each
+// referencing default patterns and one of the previous overlay patterns
+func largeOverlay(n int) map[string]string {
+	m := map[string]string{}
+	for i := 0; i < n; i++ {
+		def := fmt.Sprintf(`%%{IP:client_%[1]d} %%{WORD:verb_%[1]d} %%{URIPATH:path_%[1]d} %%{INT:status_%[1]d:int} %%{NUMBER:took_%[1]d:float}`, i)
+		if i > 1 {
+			def += fmt.Sprintf(` %%{OVERLAY_%d:prev_%d}`, i/2, i)
+		}
+		m[fmt.Sprintf("OVERLAY_%d", i)] = def
+	}
+	return m
+}
+
+func BenchmarkDenormalizePatternsFromMap(b *testing.B) {
+	defaultPatterns := CopyDefalutPatterns()
+	overlay := largeOverlay(200)
+
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	if _, invalid := DenormalizePatternsFromMap(overlay, denormalized); len(invalid) != 0 {
+		b.Fatalf("Expected the overlay to be valid, got %v", invalid)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+		DenormalizePatternsFromMap(overlay, denormalized)
+	}
+}
+
+func TestGrokRegexpJSONType(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`^%{WORD:level} meta=%{DATA:meta:json}$`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	ret, err := gr.Run(`INFO meta={"user":"bob","ids":[1,2]}`, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	v, ok := gr.GetValCastByName("meta", ret)
+	if !ok {
+		t.Fatalf("Expected JSON value to be decoded")
+	}
+	meta, ok := v.(map[string]interface{})
+	if !ok || meta["user"] != "bob" {
+		t.Fatalf("Expected decoded object, got %#v", v)
+	}
+	if ids, ok := meta["ids"].([]interface{}); !ok || len(ids) != 2 || ids[1] != float64(2) {
+		t.Errorf("Expected ids [1 2], got %#v", meta["ids"])
+	}
+
+	js, err := gr.RunJSON(`INFO meta=[true,"x"]`, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(js) != `{"level":"INFO","meta":[true,"x"]}` {
+		t.Errorf("Unexpected JSON: %s", js)
+	}
+
+	ret, err = gr.Run(`INFO meta={not json`, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := gr.GetValCastByName("meta", ret); ok {
+		t.Errorf("Expected ok=false for invalid JSON")
+	}
+}
+
+func TestResolveAll(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	base := PatternStorage{denormalized}.Freeze()
+
+	valid, invalid := ResolveAll(map[string]string{
+		"REQUEST": `%{WORD:verb} %{URIPATH:path}`,
+		"LINE":    `%{IP:client} %{REQUEST}`,
+		"BROKEN":  `%{NOSUCHPATTERN}`,
+	}, base)
+
+	for _, name := range []string{"REQUEST", "LINE", "IP", "WORD", "URIPATH"} {
+		if _, ok := valid[name]; !ok {
+			t.Errorf("Expected %s to be valid", name)
+		}
+	}
+	if len(invalid) != 1 || invalid["BROKEN"] == "" {
+		t.Errorf("Expected only BROKEN to be invalid, got %v", invalid)
+	}
+
+	gr, err := CompilePattern(`%{LINE}`, PatternStorage{valid})
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	ret, err := gr.RunMap("10.0.0.1 GET /index.html", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ret["client"] != "10.0.0.1" || ret["path"] != "/index.html" {
+		t.Errorf("Unexpected result: %v", ret)
+	}
+
+	_, invalid = ResolveAll(map[string]string{"LINE": `%{IP:client}`}, nil)
+	if invalid["LINE"] == "" {
+		t.Errorf("Expected LINE to be invalid without a storage")
+	}
+}
+
+func TestNumberSignAndExponent(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`^%{NUMBER:n:float}$`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"-1.2e3", -1200},
+		{"+42", 42},
+		{".5", 0.5},
+		{"-.5", -0.5},
+		{"6.02E+23", 6.02e23},
+		{"1e-3", 0.001},
+		{"17", 17},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			ret, err := gr.Run(tt.input, false)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			v, ok := gr.GetValCastByName("n", ret)
+			if !ok || v != tt.expected {
+				t.Errorf("Expected %v, got %v (ok %v)", tt.expected, v, ok)
+			}
+		})
+	}
+
+	if gr.Match("1e") || gr.Match("e3") {
+		t.Errorf("Expected incomplete exponents not to match")
+	}
+}
+
+func TestGrokRegexpFullMatchField(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{WORD:method} %{INT:status:int}`, storage, WithFullMatchField("_match"))
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	if names := strings.Join(gr.MatchNames(), ","); names != "method,status,_match" {
+		t.Errorf("Expected fields method,status,_match, got %s", names)
+	}
+
+	ret, err := gr.RunMap("request: GET 200 took 3ms", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ret["_match"] != "GET 200" || ret["method"] != "GET" {
+		t.Errorf("Unexpected result: %v", ret)
+	}
+
+	typed, err := gr.RunMapWithTypeInfo("GET 200", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if typed["_match"] != "GET 200" || typed["status"] != int64(200) {
+		t.Errorf("Unexpected typed result: %v", typed)
+	}
+}
+
+func TestQuoteLiteral(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	literals := []string{
+		"web-01.example.com",
+		"/var/log/app(1).log",
+		"100%{IP}",
+		"50% [done]",
+		`C:\Temp\*`,
+	}
+
+	for _, literal := range literals {
+		t.Run(literal, func(t *testing.T) {
+			gr, err := CompilePattern(`^`+QuoteLiteral(literal)+` %{INT:n}$`, storage)
+			if err != nil {
+				t.Fatalf("Failed to compile pattern: %v", err)
+			}
+			ret, err := gr.RunMap(literal+" 7", false)
+			if err != nil {
+				t.Fatalf("Expected literal to match: %v", err)
+			}
+			if ret["n"] != "7" {
+				t.Errorf("Expected n=7, got %v", ret)
+			}
+			if gr.Match("x" + literal + " 7") {
+				t.Errorf("Expected anchored literal not to match a prefixed line")
+			}
+		})
+	}
+}
+
+func TestCompilePatternDuplicateCaptureName(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	invalid := []string{
+		`%{WORD:name} %{WORD:name}`,
+		`%{WORD:name} (?:%{INT:n}|%{WORD:name})`,
+		`(?P<outer>%{WORD:outer})`,
+	}
+	for _, input := range invalid {
+		_, err := CompilePattern(input, storage)
+		if !errors.Is(err, ErrDuplicateCaptureName) {
+			t.Errorf("Expected ErrDuplicateCaptureName for %s, got %v", input, err)
+		}
+	}
+
+	if _, err := CompilePattern(`%{WORD:_match}`, storage, WithFullMatchField("_match")); !errors.Is(err, ErrDuplicateCaptureName) {
+		t.Errorf("Expected ErrDuplicateCaptureName for a full match field clash, got %v", err)
+	}
+
+	// Names shared by the branches of an alternation make a single field
+	gr, err := CompilePattern(`^(?:%{INT:id}|id-%{WORD:id})$`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if names := gr.MatchNames(); len(names) != 1 || names[0] != "id" {
+		t.Errorf("Expected a single id field, got %v", names)
+	}
+	for content, expected := range map[string]string{"42": "42", "id-abc": "abc"} {
+		ret, err := gr.RunMap(content, false)
+		if err != nil {
+			t.Fatalf("Unexpected error for %q: %v", content, err)
+		}
+		if ret["id"] != expected {
+			t.Errorf("Expected id %q for %q, got %q", expected, content, ret["id"])
+		}
+	}
+
+	gr, err = CompilePattern(`%{HTTPD_ERRORLOG}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	ret, err := gr.RunMap(`[Mon Aug 31 16:27:04.123456 2015] [core:error] [pid 12:tid 34] [client 1.1.1.1:2] AH00128: File does not exist: /x`, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ret["loglevel"] != "error" {
+		t.Errorf("Expected loglevel error, got %q", ret["loglevel"])
+	}
+	ret, err = gr.RunMap(`[Mon Aug 31 16:27:04 2015] [error] [client 1.2.3.4] File does not exist: /x`, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ret["loglevel"] != "error" {
+		t.Errorf("Expected loglevel error, got %q", ret["loglevel"])
+	}
+}
+
+func TestDenormalizeWithIgnoreTypes(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	input := `%{IP:client:ipaddr} %{INT:status:int} %{WORD:verb}`
+	if _, err := DenormalizePattern(input, storage); err == nil {
+		t.Fatalf("Expected error for unknown type without WithIgnoreTypes")
+	}
+
+	gp, err := DenormalizePatternWithOptions(input, storage, WithIgnoreTypes())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(gp.TypedVar()) != 0 {
+		t.Errorf("Expected no types, got %v", gp.TypedVar())
+	}
+	plain, err := DenormalizePattern(`%{IP:client} %{INT:status} %{WORD:verb}`, storage)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if gp.Denormalized() != plain.Denormalized() {
+		t.Errorf("Expected the same expression as the untyped pattern")
+	}
+}
+
+func TestDenormalizePatternsFromMapMissingChain(t *testing.T) {
+	_, invalid := DenormalizePatternsFromMap(map[string]string{
+		"A": `%{B:b}`,
+		"B": `x%{C}`,
+		"C": `%{MISSING}`,
+	})
+
+	expected := map[string]string{
+		"A": "no pattern found for %{MISSING}: A -> B -> C -> MISSING",
+		"B": "no pattern found for %{MISSING}: B -> C -> MISSING",
+		"C": "no pattern found for %{MISSING}",
+	}
+	for name, msg := range expected {
+		if invalid[name] != msg {
+			t.Errorf("Expected error %q for %s, got %q", msg, name, invalid[name])
+		}
+	}
+}
+
+func TestUnicodePatterns(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, invalid := DenormalizePatternsFromMap(defaultPatterns)
+	if invalid["UNICODEWORD"] != "" || invalid["UNICODENUMBER"] != "" {
+		t.Fatalf("Unexpected invalid patterns: %v", invalid)
+	}
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`^%{UNICODEWORD:user} %{UNICODEWORD:city} %{UNICODENUMBER:n}$`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	tests := []struct {
+		input string
+		user  string
+		city  string
+		n     string
+	}{
+		{"Дмитрий Москва 42", "Дмитрий", "Москва", "42"},
+		{"山田太郎 東京 -٣٤", "山田太郎", "東京", "-٣٤"},
+		{"José_2 São 7", "José_2", "São", "7"},
+	}
+	for _, tt := range tests {
+		ret, err := gr.RunMap(tt.input, false)
+		if err != nil {
+			t.Fatalf("Unexpected error for %q: %v", tt.input, err)
+		}
+		if ret["user"] != tt.user || ret["city"] != tt.city || ret["n"] != tt.n {
+			t.Errorf("Unexpected result for %q: %v", tt.input, ret)
+		}
+	}
+
+	if gr.Match("Дмитрий Москва x") {
+		t.Errorf("Expected UNICODENUMBER not to match letters")
+	}
+}
+
+func TestGrokRegexpRunCSV(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{IP:client} "%{DATA:request}" %{INT:status}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	gr.Rename("client", "ip")
+
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	if err := gr.WriteCSVHeader(w); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, line := range []string{
+		`10.0.0.1 "GET /a, /b" 200`,
+		`10.0.0.2 "POST /c" 500`,
+	} {
+		if err := gr.RunCSV(line, false, w); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := gr.RunCSV("garbage", false, w); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+	w.Flush()
+
+	expected := "ip,request,status\n10.0.0.1,\"GET /a, /b\",200\n10.0.0.2,POST /c,500\n"
+	if b.String() != expected {
+		t.Errorf("Unexpected CSV output:\n%s\nwant\n%s", b.String(), expected)
+	}
+}
+
+func TestGrokRegexpStrictConversions(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`^%{NOTSPACE:status:int} %{NOTSPACE:took:float} %{NOTSPACE:ok:bool} %{WORD:verb}$`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	ret, err := gr.RunWithTypeInfoStrict("200 1.5 true GET", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ret[0] != int64(200) || ret[1] != 1.5 || ret[2] != true || ret[3] != "GET" {
+		t.Errorf("Unexpected result: %v", ret)
+	}
+
+	_, err = gr.RunWithTypeInfoStrict("200 fast true GET", false)
+	var convErr *ConversionError
+	if !errors.As(err, &convErr) || !errors.Is(err, ErrConversion) {
+		t.Fatalf("Expected a ConversionError, got %v", err)
+	}
+	if convErr.Field != "took" || convErr.Type != GTypeFloat || convErr.Value != "fast" {
+		t.Errorf("Unexpected conversion error: %+v", convErr)
+	}
+
+	// The lenient outputs keep their historical results for failed
+	// conversions: nil for ints, 0 for floats and false for bools
+	lenient, err := gr.RunMapWithTypeInfo("x fast maybe GET", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if lenient["status"] != nil || lenient["took"] != float64(0) || lenient["ok"] != false {
+		t.Errorf("Unexpected lenient conversions, got %v", lenient)
+	}
+
+	compact, errs, err := gr.RunMapWithTypeInfoCompact("x 1.5 maybe GET", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(compact) != 2 || compact["took"] != 1.5 || compact["verb"] != "GET" {
+		t.Errorf("Expected only took and verb, got %v", compact)
+	}
+	if len(errs) != 2 || errs[0].Field != "status" || errs[1].Field != "ok" {
+		t.Errorf("Expected status and ok to be reported, got %v", errs)
+	}
+
+	if _, _, err := gr.RunMapWithTypeInfoCompact("nothing", false); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+}
+
+func TestGrokRegexpStrictOptionalField(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`^(?:%{INT:n:int}|-) (?:%{NUMBER:took:float}|-) %{WORD:w}$`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	// Typed fields that did not participate in the match are nil, without
+	// conversion error
+	ret, err := gr.RunWithTypeInfoStrict("- - abc", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ret[0] != nil || ret[1] != nil || ret[2] != "abc" {
+		t.Errorf("Unexpected result: %v", ret)
+	}
+
+	compact, errs, err := gr.RunMapWithTypeInfoCompact("- - abc", false)
+	if err != nil || len(errs) != 0 {
+		t.Fatalf("Unexpected errors: %v %v", errs, err)
+	}
+	if compact["n"] != nil || compact["w"] != "abc" {
+		t.Errorf("Unexpected result: %v", compact)
+	}
+
+	ret, err = gr.RunWithTypeInfoStrict("12 0.5 abc", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ret[0] != int64(12) || ret[1] != 0.5 {
+		t.Errorf("Unexpected result: %v", ret)
+	}
+
+	// The lenient output keeps converting an empty value
+	lenient, err := gr.RunWithTypeInfo("- - abc", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if lenient[0] != nil || lenient[1] != float64(0) {
+		t.Errorf("Unexpected lenient result: %v", lenient)
+	}
+}
+
+func TestGrokRegexpCastLenient(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{WORD:x:float} %{WORD:b:bool} %{WORD:n:int}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	ret, err := gr.Run("abc maybe xyz", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if v, ok := gr.GetValCastByName("x", ret); !ok || v != float64(0) {
+		t.Errorf("Expected (0, true) for a failed float conversion, got (%v, %v)", v, ok)
+	}
+	if v, ok := gr.GetValCastByName("b", ret); !ok || v != false {
+		t.Errorf("Expected (false, true) for a failed bool conversion, got (%v, %v)", v, ok)
+	}
+	if v, ok := gr.GetValCastByName("n", ret); ok || v != nil {
+		t.Errorf("Expected (nil, false) for a failed int conversion, got (%v, %v)", v, ok)
+	}
+}
+
+func TestGrokRegexpFieldSyntax(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{IP:server} %{NUMBER:port:int} %{COMMONAPACHELOG} (?P<extra>\w+)`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	gr.Rename("server", "host")
+
+	syntax := gr.FieldSyntax()
+	expected := map[string]string{
+		"host":        "IP",
+		"port":        "NUMBER",
+		"clientip":    "IPORHOST",
+		"timestamp":   "HTTPDATE",
+		"response":    "NUMBER",
+		"httpversion": "NUMBER",
+	}
+	for field, want := range expected {
+		if syntax[field] != want {
+			t.Errorf("%s: expected syntax %q, got %q", field, want, syntax[field])
+		}
+	}
+	if _, ok := syntax["extra"]; ok {
+		t.Errorf("inline capture should have no syntax, got %q", syntax["extra"])
+	}
+	if _, ok := syntax["server"]; ok {
+		t.Error("renamed field should be keyed by its new name")
+	}
+
+	gp, err := DenormalizePattern("%{WORD:verb} %{WORD}", storage)
+	if err != nil {
+		t.Fatalf("DenormalizePattern failed: %v", err)
+	}
+	if s := gp.FieldSyntax(); len(s) != 1 || s["verb"] != "WORD" {
+		t.Errorf("unexpected field syntax %v", s)
+	}
+}
+
+func TestGrokRegexpTypedFields(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{IP:server} %{NUMBER:port:int} %{WORD:user:string} %{NUMBER:took:float} %{GREEDYDATA:msg}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	gr.Rename("took", "duration")
+
+	typed := gr.TypedFields()
+	if strings.Join(typed, ",") != "port,user,duration" {
+		t.Errorf("unexpected typed fields %v", typed)
+	}
+
+	types := gr.FieldTypes()
+	expected := map[string]string{"port": GTypeInt, "user": GTypeStr, "duration": GTypeFloat}
+	if len(types) != len(expected) {
+		t.Errorf("expected %d types, got %v", len(expected), types)
+	}
+	for field, want := range expected {
+		if types[field] != want {
+			t.Errorf("%s: expected type %q, got %q", field, want, types[field])
+		}
+	}
+
+	gr, err = CompilePattern(`%{WORD:a} %{WORD:b}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if len(gr.TypedFields()) != 0 || len(gr.FieldTypes()) != 0 {
+		t.Errorf("expected no typed fields, got %v", gr.FieldTypes())
+	}
+}
+
+func TestDenormalizePatternMalformedReferences(t *testing.T) {
+	storage := PatternStorage{map[string]*GrokPattern{
+		"WORD": {pattern: `\w+`, denormalized: `\w+`},
+		"NIL":  nil,
+	}}
+
+	tests := []struct {
+		name  string
+		input string
+		opts  []DenormalizeOption
+	}{
+		{"no capturing group", "<<WORD>>", []DenormalizeOption{WithReferencePattern(regexp.MustCompile(`<<\w+>>`), "<<", ">>")}},
+		{"optional group", "<<>>", []DenormalizeOption{WithReferencePattern(regexp.MustCompile(`<<(\w+)?>>`), "<<", ">>")}},
+		{"nil pattern in storage", "%{NIL:x}", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DenormalizePatternWithOptions(tt.input, storage, tt.opts...); err == nil {
+				t.Error("expected an error")
+			}
+			_, errs := DenormalizePatternsFromMapWithOptions(map[string]string{"P": tt.input}, []map[string]*GrokPattern{storage[0]}, tt.opts...)
+			if _, ok := errs["P"]; !ok {
+				t.Error("expected an error from DenormalizePatternsFromMapWithOptions")
+			}
+		})
+	}
+}
+
+func TestNewPatternStorage(t *testing.T) {
+	storage := NewPatternStorage()
+	if len(storage) != 1 || !storage.IsEmpty() {
+		t.Fatalf("expected one empty map, got %v", storage)
+	}
+
+	gp, err := DenormalizePattern(`\d+`, nil)
+	if err != nil {
+		t.Fatalf("DenormalizePattern failed: %v", err)
+	}
+	storage.SetPattern("DIGITS", gp)
+	if storage.IsEmpty() {
+		t.Error("storage should not be empty after SetPattern")
+	}
+	gr, err := CompilePattern("%{DIGITS:n}", storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if values, err := gr.Run("abc 42", false); err != nil || values[0] != "42" {
+		t.Errorf("unexpected result %v %v", values, err)
+	}
+
+	if !NewPatternStorage(nil, nil).IsEmpty() || len(NewPatternStorage(nil, nil)) != 1 {
+		t.Error("nil maps should be replaced by a single empty map")
+	}
+
+	defaults, _ := DenormalizePatternsFromMap(CopyDefalutPatterns())
+	overlay := map[string]*GrokPattern{}
+	storage = NewPatternStorage(nil, overlay, defaults)
+	if len(storage) != 2 || storage.IsEmpty() {
+		t.Fatalf("unexpected storage of %d maps", len(storage))
+	}
+	if _, ok := storage.GetPattern("IP"); !ok {
+		t.Error("expected IP to be found in the defaults")
+	}
+	if !(PatternStorage{}).IsEmpty() || !(PatternStorage{overlay}).IsEmpty() {
+		t.Error("storages without patterns should be empty")
+	}
+}
+
+func TestWithWordBoundaries(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	tests := []struct {
+		pattern  string
+		content  string
+		expected []string // nil for a mismatch
+	}{
+		{"%{INT:n}", "v 123x", nil},
+		{"%{INT:n}", "abc 123", []string{"123"}},
+		{"%{INT:n}", "x -5 y", []string{"-5"}},
+		{"%{POSINT:n}", "id123", nil},
+		{"%{POSINT:n}", "id=123!", []string{"123"}},
+		{"%{WORD:w}", "abc123", []string{"abc123"}},
+		{"%{USERNAME:u}", "~alice.b", []string{"alice.b"}},
+		{"%{INT:n}ms", "10ms", nil},
+		{"%{INT}ms", "10ms", []string{"10"}},
+		{"%{DATA:d}!", "x!", []string{"x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.content, func(t *testing.T) {
+			gp, err := DenormalizePatternWithOptions(tt.pattern, storage, WithWordBoundaries())
+			if err != nil {
+				t.Fatalf("DenormalizePatternWithOptions failed: %v", err)
+			}
+			re := regexp.MustCompile(gp.Denormalized())
+			m := re.FindStringSubmatch(tt.content)
+			if tt.expected == nil {
+				if m != nil {
+					t.Errorf("expected no match, got %q", m)
+				}
+				return
+			}
+			if m == nil {
+				t.Fatalf("expected a match for %s", gp.Denormalized())
+			}
+			if strings.Join(m[1:], ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("expected %q, got %q", tt.expected, m[1:])
+			}
+		})
+	}
+
+	gp, _ := DenormalizePatternWithOptions("%{DATA:d} %{INT:n}", storage, WithWordBoundaries())
+	if expected := `(?P<d>.*?) (?P<n>(?:[+-]?(?:[0-9]+)))\b`; gp.Denormalized() != expected {
+		t.Errorf("expected %s, got %s", expected, gp.Denormalized())
+	}
+
+	start, end := wordEdges(`(?:\b\w+\b|[0-9]{2,}x)`)
+	if !start || !end {
+		t.Errorf("expected word edges, got %v %v", start, end)
+	}
+	if start, end := wordEdges(`a?`); start || end {
+		t.Errorf("an expression matching the empty string has no word edges, got %v %v", start, end)
+	}
+}
+
+func TestGrokRegexpRunSingle(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`request_id=%{UUID:id}`, storage, WithDashAsEmpty())
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	value, ok, err := gr.RunSingle("GET / request_id=123e4567-e89b-12d3-a456-426614174000 200", false)
+	if err != nil || !ok || value != "123e4567-e89b-12d3-a456-426614174000" {
+		t.Errorf("unexpected result %q %v %v", value, ok, err)
+	}
+	if _, _, err := gr.RunSingle("GET / 200", false); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected ErrMismatch, got %v", err)
+	}
+
+	opt, _ := CompilePattern(`a(?: %{WORD:w})?`, storage, WithDashAsEmpty())
+	if value, ok, err := opt.RunSingle("a", false); err != nil || ok || value != "" {
+		t.Errorf("expected a non participating field, got %q %v %v", value, ok, err)
+	}
+	if value, ok, err := opt.RunSingle("a b", true); err != nil || !ok || value != "b" {
+		t.Errorf("unexpected result %q %v %v", value, ok, err)
+	}
+	dash, _ := CompilePattern(`user=%{NOTSPACE:user}`, storage, WithDashAsEmpty())
+	if value, ok, err := dash.RunSingle("user=-", false); err != nil || !ok || value != "" {
+		t.Errorf("expected the dash to be returned empty, got %q %v %v", value, ok, err)
+	}
+
+	multi, _ := CompilePattern(`%{WORD:a} %{WORD:b}`, storage)
+	if _, _, err := multi.RunSingle("x y", false); err == nil {
+		t.Error("expected an error for a pattern with two fields")
+	}
+	if _, _, err := (&GrokRegexp{}).RunSingle("x", false); !errors.Is(err, ErrNotCompiled) {
+		t.Errorf("expected ErrNotCompiled, got %v", err)
+	}
+}
+
+func BenchmarkGrokRegexpRunSingle(b *testing.B) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`request_id=%{UUID:id}`, storage)
+	if err != nil {
+		b.Fatalf("Failed to compile pattern: %v", err)
+	}
+	line := "GET /index.html request_id=123e4567-e89b-12d3-a456-426614174000 200"
+
+	b.Run("Run", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = gr.Run(line, false)
+		}
+	})
+	b.Run("RunSingle", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _, _ = gr.RunSingle(line, false)
+		}
+	})
+}
+
+func TestCompilePatternAlternationSharedAlias(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	defaultPatterns["HOSTPORT"] = `(?:%{IPV4:host}|\[%{IPV6:host}\]|%{HOSTNAME:host}):%{POSINT:port:int}`
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	tests := []struct {
+		pattern  string
+		content  string
+		expected map[string]string
+	}{
+		{`%{IPV4:ip}|%{IPV6:ip}`, "10.0.0.1", map[string]string{"ip": "10.0.0.1"}},
+		{`%{IPV4:ip}|%{IPV6:ip}`, "fe80::1", map[string]string{"ip": "fe80::1"}},
+		{`from (?:%{IPV4:ip}|%{IPV6:ip}) port %{INT:port}`, "from ::1 port 22", map[string]string{"ip": "::1", "port": "22"}},
+		{`%{HOSTPORT}`, "[::1]:8080", map[string]string{"host": "::1", "port": "8080"}},
+		{`%{HOSTPORT}`, "example.com:80", map[string]string{"host": "example.com", "port": "80"}},
+		{`^(?:%{INT:v:int}|%{WORD:v}|"%{DATA:v}")$`, `"a b"`, map[string]string{"v": "a b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.content, func(t *testing.T) {
+			gr, err := CompilePattern(tt.pattern, storage)
+			if err != nil {
+				t.Fatalf("Failed to compile pattern: %v", err)
+			}
+			if len(gr.MatchNames()) != len(tt.expected) {
+				t.Errorf("expected fields %v, got %v", tt.expected, gr.MatchNames())
+			}
+			ret, err := gr.RunMap(tt.content, false)
+			if err != nil {
+				t.Fatalf("RunMap failed: %v", err)
+			}
+			for k, v := range tt.expected {
+				if ret[k] != v {
+					t.Errorf("%s: expected %q, got %q", k, v, ret[k])
+				}
+			}
+		})
+	}
+
+	gr, err := CompilePattern(`%{HOSTPORT}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	typed, err := gr.RunMapWithTypeInfo("10.0.0.1:443", false)
+	if err != nil || typed["host"] != "10.0.0.1" || typed["port"] != int64(443) {
+		t.Errorf("unexpected typed result %v %v", typed, err)
+	}
+}
+
+func TestSortedInvalid(t *testing.T) {
+	_, invalid := DenormalizePatternsFromMap(map[string]string{
+		"C":  `%{MISSING}`,
+		"A":  `%{WORD:w:nope}`,
+		"B":  `%{B}`,
+		"OK": `\d+`,
+	})
+
+	sorted := SortedInvalid(invalid)
+	if len(sorted) != 3 {
+		t.Fatalf("expected 3 invalid patterns, got %v", sorted)
+	}
+	for i, name := range []string{"A", "B", "C"} {
+		if sorted[i].Name != name || sorted[i].Error != invalid[name] {
+			t.Errorf("%d: expected %s: %s, got %v", i, name, invalid[name], sorted[i])
+		}
+	}
+
+	if len(SortedInvalid(nil)) != 0 {
+		t.Error("expected no invalid pattern for a nil map")
+	}
+}
+
+func TestGrokRegexpRecompile(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	defaultPatterns["APPID"] = `[a-z]+`
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`app=%{APPID:app} code=%{INT:code:int}`, storage, WithAnchorStart())
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	gr.Rename("app", "application")
+	gr.AddFieldPattern("code", regexp.MustCompile(`^[1-5]`))
+
+	if _, err := gr.Run("app=web-1 code=200", false); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("expected ErrMismatch before the update, got %v", err)
+	}
+
+	if _, err := storage.Update("APPID", `[a-z]+-\d+`); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	updated, err := gr.Recompile(storage)
+	if err != nil {
+		t.Fatalf("Recompile failed: %v", err)
+	}
+
+	ret, err := updated.RunMapWithTypeInfo("app=web-1 code=200", false)
+	if err != nil {
+		t.Fatalf("RunMapWithTypeInfo failed: %v", err)
+	}
+	if ret["application"] != "web-1" || ret["code"] != int64(200) {
+		t.Errorf("unexpected result %v", ret)
+	}
+	if _, err := updated.Run("app=web-1 code=900", false); !errors.Is(err, ErrFieldConstraint) {
+		t.Errorf("expected the field constraint to be kept, got %v", err)
+	}
+	if _, err := updated.Run("x app=web-1 code=200", false); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected the compile options to be kept, got %v", err)
+	}
+	if _, err := gr.Run("app=web-1 code=200", false); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected the receiver to be unchanged, got %v", err)
+	}
+
+	if _, err := gr.Recompile(PatternStorage{}); err == nil {
+		t.Error("expected an error when references cannot be resolved")
+	}
+	if _, err := (&GrokRegexp{}).Recompile(storage); !errors.Is(err, ErrNotCompiled) {
+		t.Errorf("expected ErrNotCompiled, got %v", err)
+	}
+}
+
+func TestMACType(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{MAC:m:mac} color=%{HEXCOLOR:c}`, storage, WithConversionCache(8))
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	for _, content := range []string{
+		"00:1A:2b:3c:4d:5e color=#ff8800",
+		"00-1a-2b-3c-4d-5e color=#ff8800",
+		"001a.2b3c.4d5e color=ff8800",
+	} {
+		values, err := gr.RunWithTypeInfo(content, false)
+		if err != nil {
+			t.Fatalf("%s: RunWithTypeInfo failed: %v", content, err)
+		}
+		mac, ok := values[0].(net.HardwareAddr)
+		if !ok || mac.String() != "00:1a:2b:3c:4d:5e" {
+			t.Errorf("%s: expected a net.HardwareAddr, got %#v", content, values[0])
+		}
+		if c, _ := gr.GetValAnyByName("c", values); !strings.HasSuffix(c.(string), "ff8800") {
+			t.Errorf("%s: unexpected color %v", content, c)
+		}
+	}
+
+	j, err := gr.RunJSON("00:1a:2b:3c:4d:5e color=#ABCDEF", false)
+	if err != nil {
+		t.Fatalf("RunJSON failed: %v", err)
+	}
+	if expected := `{"c":"#ABCDEF","m":"00:1a:2b:3c:4d:5e"}`; string(j) != expected {
+		t.Errorf("expected %s, got %s", expected, j)
+	}
+
+	if _, err := CompilePattern(`%{HEXCOLOR:c}`, storage); err != nil {
+		t.Errorf("Failed to compile HEXCOLOR: %v", err)
+	}
+	if ok, _ := regexp.MatchString(`^`+denormalized["HEXCOLOR"].Denormalized()+`$`, "#12345g"); ok {
+		t.Error("HEXCOLOR should not match #12345g")
+	}
+
+	hex, err := CompilePattern(`%{HEXCOLOR:c}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile HEXCOLOR: %v", err)
+	}
+	for _, content := range []string{"#abc", "#aabbcc", "aabbcc", "color: #FFF;"} {
+		if !hex.Match(content) {
+			t.Errorf("HEXCOLOR should match %q", content)
+		}
+	}
+	for _, content := range []string{
+		"commit 3b18e512dba79e4c8300dd08aeb37f8e728b8dad",
+		"#abcdef12",
+		"#abcd",
+		"abc",
+	} {
+		if hex.Match(content) {
+			t.Errorf("HEXCOLOR should not match %q", content)
+		}
+	}
+}
+
+func TestMinimalStorage(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	inputs := []string{`%{IP:client} %{WORD:verb}`, `%{SYSLOGTIMESTAMP}`, `%{IP} again`}
+	minimal, err := MinimalStorage(inputs, storage)
+	if err != nil {
+		t.Fatalf("MinimalStorage failed: %v", err)
+	}
+
+	expected := []string{"IP", "IPV4", "IPV6", "WORD", "SYSLOGTIMESTAMP", "MONTH", "MONTHDAY", "TIME", "HOUR", "MINUTE", "SECOND"}
+	for _, name := range expected {
+		if _, ok := minimal.GetPattern(name); !ok {
+			t.Errorf("expected %s in the minimal storage", name)
+		}
+	}
+	if len(minimal[0]) != len(expected) {
+		t.Errorf("expected %d patterns, got %d", len(expected), len(minimal[0]))
+	}
+
+	for _, input := range inputs {
+		full, _ := DenormalizePattern(input, storage)
+		small, err := DenormalizePattern(input, minimal)
+		if err != nil {
+			t.Fatalf("Failed to denormalize %s with the minimal storage: %v", input, err)
+		}
+		if full.Denormalized() != small.Denormalized() {
+			t.Errorf("%s: denormalized patterns differ", input)
+		}
+	}
+
+	if _, err := MinimalStorage([]string{`%{NOPE}`}, storage); err == nil || !strings.Contains(err.Error(), "%{NOPE}") {
+		t.Errorf("expected an error naming the missing pattern, got %v", err)
+	}
+
+	// References written with custom delimiters are followed
+	delimited, invalid := DenormalizePatternsFromMapWithOptions(map[string]string{
+		"PAIR": `<<WORD:key>>=<<INT:value>>`,
+	}, []map[string]*GrokPattern{denormalized}, WithDelimiters("<<", ">>"))
+	if len(invalid) != 0 {
+		t.Fatalf("Unexpected invalid patterns: %v", invalid)
+	}
+	minimal, err = MinimalStorage([]string{`<<PAIR>>`}, PatternStorage{delimited, denormalized}, WithDelimiters("<<", ">>"))
+	if err != nil {
+		t.Fatalf("MinimalStorage failed: %v", err)
+	}
+	for _, name := range []string{"PAIR", "WORD", "INT"} {
+		if _, ok := minimal.GetPattern(name); !ok {
+			t.Errorf("expected %s in the minimal storage", name)
+		}
+	}
+	if _, err := MinimalStorage([]string{`<<NOPE>>`}, storage, WithDelimiters("<<", ">>")); err == nil || !strings.Contains(err.Error(), "<<NOPE>>") {
+		t.Errorf("expected an error naming the missing pattern, got %v", err)
+	}
+}
+
+func TestGrokRegexpRunJSONRaw(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`id=%{INT:id:int} n=%{NOTSPACE:n:int} ratio=%{NOTSPACE:ratio:float} ok=%{WORD:ok:bool} raw=%{NOTSPACE:raw} data=%{NOTSPACE:data:json} ids=%{DATA:ids:int}$`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	gr.SetMultiValue("ids", ",")
+
+	tests := []struct {
+		content  string
+		expected string
+	}{
+		{
+			`id=10000000000000000001 n=007 ratio=0.1000000000000000055511151231257827 ok=true raw=42 data={"a":[1,2]} ids=1,18446744073709551616`,
+			`{"data":{"a":[1,2]},"id":10000000000000000001,"ids":[1,18446744073709551616],"n":7,"ok":true,"ratio":0.1000000000000000055511151231257827,"raw":"42"}`,
+		},
+		{
+			`id=-5 n=0x1f ratio=1e3 ok=nope raw="q" data={bad ids=`,
+			`{"data":null,"id":-5,"ids":[],"n":31,"ok":null,"ratio":1e3,"raw":"\"q\""}`,
+		},
+		{
+			`id=1 n=abc ratio=NaN ok=0 raw=x data="s" ids=2`,
+			`{"data":"s","id":1,"ids":[2],"n":null,"ok":false,"ratio":null,"raw":"x"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		j, err := gr.RunJSONRaw(tt.content, false)
+		if err != nil {
+			t.Fatalf("RunJSONRaw failed: %v", err)
+		}
+		if string(j) != tt.expected {
+			t.Errorf("got      %s\nexpected %s", j, tt.expected)
+		}
+	}
+
+	comma, _ := CompilePattern(`%{NOTSPACE:v:float}`, storage, WithDecimalComma())
+	if j, _ := comma.RunJSONRaw("3,14159265358979323846", false); string(j) != `{"v":3.14159265358979323846}` {
+		t.Errorf("unexpected result %s", j)
+	}
+	if _, err := gr.RunJSONRaw("nothing", false); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected ErrMismatch, got %v", err)
+	}
+}
+
+func TestPatternStorageNames(t *testing.T) {
+	storage := PatternStorage{
+		map[string]*GrokPattern{"B": {}, "A": {}},
+		map[string]*GrokPattern{"C": {}, "A": {}},
+	}
+
+	var namer PatternNamer = storage
+	if names := namer.Names(); strings.Join(names, ",") != "A,B,C" {
+		t.Errorf("expected A,B,C, got %v", names)
+	}
+
+	namer, ok := storage.Freeze().(PatternNamer)
+	if !ok {
+		t.Fatal("a frozen storage should list its names")
+	}
+	if names := namer.Names(); strings.Join(names, ",") != "A,B,C" {
+		t.Errorf("expected A,B,C from the frozen storage, got %v", names)
+	}
+
+	if names := (PatternStorage{}).Names(); len(names) != 0 {
+		t.Errorf("expected no names, got %v", names)
+	}
+
+	denormalized, _ := DenormalizePatternsFromMap(CopyDefalutPatterns())
+	if names := (PatternStorage{denormalized}).Names(); len(names) != len(denormalized) {
+		t.Errorf("expected %d names, got %d", len(denormalized), len(names))
+	}
+}
+
+func TestWithDefaultLocation(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database not available: %v", err)
+	}
+
+	tests := []struct {
+		content  string
+		loc      *time.Location
+		expected string
+	}{
+		// naive timestamps are read in the default location and returned in UTC
+		{"2024-01-15 10:30:00", nil, "2024-01-15T10:30:00Z"},
+		{"2024-01-15 10:30:00", ny, "2024-01-15T15:30:00Z"},
+		{"2024-07-15 10:30:00", ny, "2024-07-15T14:30:00Z"},
+		{"2024-01-15 10:30:00,123", ny, "2024-01-15T15:30:00.123Z"},
+		// timestamps with a zone keep their offset
+		{"2024-01-15T10:30:00+02:00", ny, "2024-01-15T10:30:00+02:00"},
+		{"2024-01-15T10:30:00Z", ny, "2024-01-15T10:30:00Z"},
+		{"15/Jan/2024:10:30:00 -0500", ny, "2024-01-15T10:30:00-05:00"},
+		{"15/Jan/2024:10:30:00 +0100", nil, "2024-01-15T10:30:00+01:00"},
+	}
+
+	for _, tt := range tests {
+		var opts []CompileOption
+		if tt.loc != nil {
+			opts = append(opts, WithDefaultLocation(tt.loc))
+		}
+		gr, err := CompilePattern(`ts=%{GREEDYDATA:ts:timestamp}`, storage, opts...)
+		if err != nil {
+			t.Fatalf("Failed to compile pattern: %v", err)
+		}
+
+		values, err := gr.RunWithTypeInfoStrict("ts="+tt.content, false)
+		if err != nil {
+			t.Fatalf("%s: RunWithTypeInfoStrict failed: %v", tt.content, err)
+		}
+		ts, ok := values[0].(time.Time)
+		if !ok {
+			t.Fatalf("%s: expected a time.Time, got %#v", tt.content, values[0])
+		}
+		if got := ts.Format(time.RFC3339Nano); got != tt.expected {
+			t.Errorf("%s in %v: expected %s, got %s", tt.content, tt.loc, tt.expected, got)
+		}
+
+		var dst struct {
+			TS time.Time `grok:"ts"`
+		}
+		if err := gr.Scan("ts="+tt.content, &dst); err != nil || !dst.TS.Equal(ts) {
+			t.Errorf("%s: Scan gave %v %v, expected %v", tt.content, dst.TS, err, ts)
+		}
+	}
+
+	gr, _ := CompilePattern(`ts=%{GREEDYDATA:ts:timestamp}`, storage, WithDefaultLocation(ny))
+	if _, err := gr.RunWithTypeInfoStrict("ts=yesterday", false); !errors.Is(err, ErrConversion) {
+		t.Errorf("expected ErrConversion, got %v", err)
+	}
+}
+
+func TestDropType(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{IP:client} %{WORD:_:drop} %{URIPATH:path} %{INT:status:drop} %{INT:bytes:int}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if names := strings.Join(gr.MatchNames(), ","); names != "client,path,bytes" {
+		t.Errorf("expected dropped fields to be left out, got %s", names)
+	}
+	if _, ok := gr.FieldSyntax()["status"]; ok {
+		t.Error("a dropped field should have no syntax")
+	}
+
+	ret, err := gr.RunMap("10.0.0.1 GET /index.html 200 512", false)
+	if err != nil {
+		t.Fatalf("RunMap failed: %v", err)
+	}
+	if len(ret) != 3 || ret["client"] != "10.0.0.1" || ret["path"] != "/index.html" || ret["bytes"] != "512" {
+		t.Errorf("unexpected result %v", ret)
+	}
+	j, err := gr.RunJSON("10.0.0.1 GET /index.html 200 512", false)
+	if err != nil || string(j) != `{"bytes":512,"client":"10.0.0.1","path":"/index.html"}` {
+		t.Errorf("unexpected JSON %s %v", j, err)
+	}
+
+	// The dropped segment is still required to match
+	if _, err := gr.RunMap("10.0.0.1 GET /index.html OK 512", false); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected ErrMismatch, got %v", err)
+	}
+
+	gp, err := DenormalizePattern(`%{INT:n:drop}`, storage)
+	if err != nil {
+		t.Fatalf("DenormalizePattern failed: %v", err)
+	}
+	if len(gp.TypedVar()) != 0 {
+		t.Errorf("a dropped field should have no type, got %v", gp.TypedVar())
+	}
+}
+
+func TestWithThousandsSeparator(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	tests := []struct {
+		opts     []CompileOption
+		content  string
+		expected []interface{}
+	}{
+		{[]CompileOption{WithThousandsSeparator(',')}, "1,234,567 1,234.5", []interface{}{int64(1234567), 1234.5}},
+		{[]CompileOption{WithThousandsSeparator(',')}, "-12,000 0.25", []interface{}{int64(-12000), 0.25}},
+		{[]CompileOption{WithThousandsSeparator('.'), WithDecimalComma()}, "1.234.567 1.234,5", []interface{}{int64(1234567), 1234.5}},
+		{[]CompileOption{WithThousandsSeparator('\'')}, "1'234 9'999.5", []interface{}{int64(1234), 9999.5}},
+		// a separator that is also the decimal separator is ignored
+		{[]CompileOption{WithThousandsSeparator(','), WithDecimalComma()}, "7 3,5", []interface{}{int64(7), 3.5}},
+		{[]CompileOption{WithThousandsSeparator('.')}, "7 3.5", []interface{}{int64(7), 3.5}},
+	}
+
+	for _, tt := range tests {
+		gr, err := CompilePattern(`%{NOTSPACE:n:int} %{NOTSPACE:f:float}`, storage, tt.opts...)
+		if err != nil {
+			t.Fatalf("Failed to compile pattern: %v", err)
+		}
+		values, err := gr.RunWithTypeInfoStrict(tt.content, false)
+		if err != nil {
+			t.Fatalf("%s: RunWithTypeInfoStrict failed: %v", tt.content, err)
+		}
+		if values[0] != tt.expected[0] || values[1] != tt.expected[1] {
+			t.Errorf("%s: expected %v, got %v", tt.content, tt.expected, values)
+		}
+	}
+
+	gr, _ := CompilePattern(`%{DATA:n:int}$`, storage, WithThousandsSeparator(' '))
+	values, err := gr.RunWithTypeInfoStrict("1 234 567", false)
+	if err != nil || values[0] != int64(1234567) {
+		t.Errorf("expected 1234567, got %v %v", values, err)
+	}
+	if v, ok := gr.GetValCastByName("n", []string{"9,999"}); ok {
+		t.Errorf("a comma should not be removed with a space separator, got %v", v)
+	}
+
+	plain, _ := CompilePattern(`%{NOTSPACE:n:int}`, storage)
+	if _, err := plain.RunWithTypeInfoStrict("1,234,567", false); !errors.Is(err, ErrConversion) {
+		t.Errorf("expected ErrConversion without the option, got %v", err)
+	}
+}
+
+func TestPatternStorageWith(t *testing.T) {
+	defaults, _ := DenormalizePatternsFromMap(CopyDefalutPatterns())
+	plugin, _ := DenormalizePatternsFromMap(map[string]string{
+		"WORD":     `[a-z]+`,
+		"PLUGINID": `plg-\d+`,
+	})
+
+	base := PatternStorage{defaults}
+	storage := base.With(PatternStorage{plugin})
+	if len(base) != 1 || len(storage) != 2 {
+		t.Fatalf("expected the receiver to be unchanged, got %d and %d maps", len(base), len(storage))
+	}
+
+	gr, err := CompilePattern(`%{PLUGINID:id} %{WORD:w} %{IP:ip}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if _, err := gr.Run("plg-1 ABC 10.0.0.1", false); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected the plugin WORD to shadow the default one, got %v", err)
+	}
+	if ret, err := gr.RunMap("plg-1 abc 10.0.0.1", false); err != nil || ret["id"] != "plg-1" {
+		t.Errorf("unexpected result %v %v", ret, err)
+	}
+
+	storage.SetPattern("EXTRA", &GrokPattern{denormalized: "x"})
+	if _, ok := defaults["EXTRA"]; !ok {
+		t.Error("SetPattern should store in the last map of the receiver")
+	}
+	if len((PatternStorage{}).With(nil)) != 0 {
+		t.Error("combining empty storages should give an empty storage")
+	}
+
+	// With an empty receiver, SetPattern stores in the last map of other
+	layer := map[string]*GrokPattern{}
+	(PatternStorage{}).With(PatternStorage{layer}).SetPattern("EXTRA", &GrokPattern{denormalized: "x"})
+	if _, ok := layer["EXTRA"]; !ok {
+		t.Error("SetPattern should store in the last map of other when the receiver is empty")
+	}
+}
+
+func TestGrokRegexpRunDetailedAndKeepRaw(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	pattern := `zip=%{NOTSPACE:zip:int} id=%{NOTSPACE:id:int} tags=%{NOTSPACE:tags:int} %{WORD:w}`
+	gr, err := CompilePattern(pattern, storage, WithKeepRaw("zip", "tags"))
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	gr.SetMultiValue("tags", ",")
+
+	ret, err := gr.RunMapWithTypeInfo("zip=00712 id=007 tags=01,2 x", false)
+	if err != nil {
+		t.Fatalf("RunMapWithTypeInfo failed: %v", err)
+	}
+	if ret["zip"] != "00712" || ret["id"] != int64(7) || ret["w"] != "x" {
+		t.Errorf("unexpected result %v", ret)
+	}
+	if tags, ok := ret["tags"].([]string); !ok || strings.Join(tags, "|") != "01|2" {
+		t.Errorf("expected raw tags, got %#v", ret["tags"])
+	}
+
+	j, err := gr.RunJSONRaw("zip=00712 id=007 tags=01,2 x", false)
+	if err != nil || string(j) != `{"id":7,"tags":["01","2"],"w":"x","zip":"00712"}` {
+		t.Errorf("unexpected JSON %s %v", j, err)
+	}
+
+	// Values kept raw are still checked
+	if _, err := gr.RunWithTypeInfoStrict("zip=ABC id=1 tags=1 x", false); !errors.Is(err, ErrConversion) {
+		t.Errorf("expected ErrConversion, got %v", err)
+	}
+
+	gr.Rename("id", "ident")
+	results, err := gr.RunDetailed("zip=00712 id=0x1F tags=3,x y", false)
+	if err != nil {
+		t.Fatalf("RunDetailed failed: %v", err)
+	}
+	expected := []FieldResult{
+		{Name: "zip", Raw: "00712", Typed: int64(712)},
+		{Name: "ident", Raw: "0x1F", Typed: int64(31)},
+		{Name: "tags", Raw: "3,x"},
+		{Name: "w", Raw: "y", Typed: "y"},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %v", len(expected), results)
+	}
+	for i, r := range results {
+		if r.Name != expected[i].Name || r.Raw != expected[i].Raw || (expected[i].Typed != nil && r.Typed != expected[i].Typed) {
+			t.Errorf("%d: expected %+v, got %+v", i, expected[i], r)
+		}
+	}
+	if results[2].Typed != nil || !errors.Is(results[2].Err, ErrConversion) {
+		t.Errorf("expected a conversion error for tags, got %+v", results[2])
+	}
+	if results[0].Err != nil || results[3].Err != nil {
+		t.Errorf("unexpected errors %v %v", results[0].Err, results[3].Err)
+	}
+}
+
+func TestGrokRegexpRunMany(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`^%{WORD:verb} %{INT:id:int}$`, storage, WithConversionCache(8))
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	lines := make([]string, 100)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("get %d", i)
+		if i%10 == 3 {
+			lines[i] = "no match"
+		}
+	}
+
+	for _, concurrency := range []int{0, 1, 4, 200} {
+		results, errs := gr.RunMany(lines, false, concurrency)
+		if len(results) != len(lines) || len(errs) != len(lines) {
+			t.Fatalf("concurrency %d: expected %d results, got %d and %d errors", concurrency, len(lines), len(results), len(errs))
+		}
+		for i := range lines {
+			if i%10 == 3 {
+				if !errors.Is(errs[i], ErrMismatch) || results[i] != nil {
+					t.Errorf("concurrency %d: line %d: expected ErrMismatch, got %v %v", concurrency, i, results[i], errs[i])
+				}
+				continue
+			}
+			if errs[i] != nil || results[i]["id"] != strconv.Itoa(i) {
+				t.Errorf("concurrency %d: line %d: unexpected result %v %v", concurrency, i, results[i], errs[i])
+			}
+		}
+	}
+
+	if results, errs := gr.RunMany(nil, false, 4); len(results) != 0 || len(errs) != 0 {
+		t.Errorf("expected no results for no lines, got %v %v", results, errs)
+	}
+}
+
+func TestGrokRegexpCollapseWhitespace(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	pattern := `^%{WORD:name} %{INT:pid:int} %{NUMBER:cpu:float} %{DATA:command}$`
+	row := "nginx      1234   0.5    nginx:  worker\t\tprocess"
+
+	gr, err := CompilePattern(pattern, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if _, err := gr.RunMap(row, false); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch without the option, got %v", err)
+	}
+
+	gr, err = CompilePattern(pattern, storage, WithCollapseWhitespace())
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	values, err := gr.RunMapWithTypeInfo(row, false)
+	if err != nil {
+		t.Fatalf("RunMapWithTypeInfo failed: %v", err)
+	}
+	if values["name"] != "nginx" || values["pid"] != int64(1234) || values["cpu"] != 0.5 || values["command"] != "nginx: worker process" {
+		t.Errorf("Unexpected values %v", values)
+	}
+
+	if got := collapseWhitespace("  a \t\n b\xffc  "); got != " a b\xffc " {
+		t.Errorf("Unexpected collapsed content %q", got)
+	}
+}
+
+func TestGrokRegexpMaxInputLen(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{WORD:verb} %{INT:id}`, storage, WithMaxInputLen(10))
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	if values, err := gr.Run("get 123456", false); err != nil || values[1] != "123456" {
+		t.Errorf("Unexpected result for content at the limit: %v %v", values, err)
+	}
+	if values, err := gr.RunBytes([]byte("get 42"), false); err != nil || values[0] != "get" {
+		t.Errorf("Unexpected RunBytes result: %v %v", values, err)
+	}
+
+	long := "get " + strings.Repeat("1", 20)
+	if _, err := gr.Run(long, false); !errors.Is(err, ErrInputTooLarge) {
+		t.Errorf("Expected ErrInputTooLarge from Run, got %v", err)
+	}
+	if _, err := gr.RunBytes([]byte(long), false); !errors.Is(err, ErrInputTooLarge) {
+		t.Errorf("Expected ErrInputTooLarge from RunBytes, got %v", err)
+	}
+	if _, err := gr.RunMap(long, false); !errors.Is(err, ErrInputTooLarge) {
+		t.Errorf("Expected ErrInputTooLarge from RunMap, got %v", err)
+	}
+	if gr.Match(long) {
+		t.Error("Expected oversized content not to match")
+	}
+
+	gr, err = CompilePattern(`%{WORD:verb} %{INT:id}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if _, err := gr.Run(long, false); err != nil {
+		t.Errorf("Unexpected error without limit: %v", err)
+	}
+}
+
+func TestGrokPatternString(t *testing.T) {
+	storage := PatternStorage{map[string]*GrokPattern{
+		"NUM":  {pattern: `\d+`, denormalized: `\d+`, varbType: map[string]string{}},
+		"WORD": {pattern: `\w+`, denormalized: `\w+`, varbType: map[string]string{}},
+	}}
+
+	gp, err := DenormalizePattern(`%{WORD:verb} %{NUM:took:float} %{NUM:code:int} %{NUM:b:int}`, storage)
+	if err != nil {
+		t.Fatalf("DenormalizePattern failed: %v", err)
+	}
+	expected := "pattern: %{WORD:verb} %{NUM:took:float} %{NUM:code:int} %{NUM:b:int}\n" +
+		`denormalized: (?P<verb>\w+) (?P<took>\d+) (?P<code>\d+) (?P<b>\d+)` + "\n" +
+		"types:\n  b: int\n  code: int\n  took: float"
+	for i := 0; i < 5; i++ {
+		if s := gp.String(); s != expected {
+			t.Fatalf("unexpected string:\n%s\nexpected:\n%s", s, expected)
+		}
+	}
+	if s := fmt.Sprint(gp); s != expected {
+		t.Errorf("unexpected formatted pattern:\n%s", s)
+	}
+
+	gp, err = DenormalizePattern(`%{WORD}`, storage)
+	if err != nil {
+		t.Fatalf("DenormalizePattern failed: %v", err)
+	}
+	if s := gp.String(); s != "pattern: %{WORD}\ndenormalized: (\\w+)\ntypes:" {
+		t.Errorf("unexpected string %q", s)
+	}
+}
+
+func TestCompilePatternAlternatives(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePatternAlternatives([]string{
+		`^%{IP:client} %{WORD:verb} %{INT:status:int}$`,
+		`^%{WORD:verb} %{URIPATHPARAM:path} %{INT:status:int}$`,
+		`^%{WORD:verb} .*$`,
+	}, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile alternatives: %v", err)
+	}
+	if names := strings.Join(gr.MatchNames(), ","); names != "client,verb,status,path" {
+		t.Errorf("unexpected match names %s", names)
+	}
+
+	tests := []struct {
+		content  string
+		expected map[string]interface{}
+	}{
+		{"10.0.0.1 GET 200", map[string]interface{}{"client": "10.0.0.1", "verb": "GET", "status": int64(200), "path": ""}},
+		{"GET /index.html 404", map[string]interface{}{"client": "", "verb": "GET", "status": int64(404), "path": "/index.html"}},
+		{"GET anything else", map[string]interface{}{"client": "", "verb": "GET", "status": nil, "path": ""}},
+	}
+	for _, tt := range tests {
+		values, err := gr.RunMapWithTypeInfo(tt.content, false)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.content, err)
+			continue
+		}
+		for field, want := range tt.expected {
+			if values[field] != want {
+				t.Errorf("%s: %s: expected %v, got %v", tt.content, field, want, values[field])
+			}
+		}
+	}
+	if _, err := gr.Run("!", false); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+
+	if _, err := CompilePatternAlternatives(nil, storage); err == nil {
+		t.Error("expected an error without alternative")
+	}
+	if _, err := CompilePatternAlternatives([]string{`%{WORD:w}`, `%{NOPE:w}`}, storage); err == nil || !strings.Contains(err.Error(), "alternative 1") {
+		t.Errorf("expected the failing alternative in the error, got %v", err)
+	}
+	if _, err := CompilePatternAlternatives([]string{`%{INT:n:int}`, `%{INT:n:float}`}, storage); err == nil {
+		t.Error("expected an error for conflicting types")
+	}
+}
+
+func TestGrokRegexpRangeConstraint(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{IP:host}:%{NUMBER:port:int} load=%{NUMBER:load:float} id=%{NOTSPACE:id}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	gr.AddRangeConstraint("port", 1, 65535)
+	gr.AddRangeConstraint("load", 0, 1)
+	gr.AddRangeConstraint("id", 100, 200)
+
+	tests := []struct {
+		content string
+		valid   bool
+	}{
+		{"10.0.0.1:443 load=0.5 id=150", true},
+		{"10.0.0.1:65535 load=1 id=100", true},
+		{"10.0.0.1:0 load=0.5 id=150", false},
+		{"10.0.0.1:70000 load=0.5 id=150", false},
+		{"10.0.0.1:443 load=1.5 id=150", false},
+		{"10.0.0.1:443 load=0.5 id=250", false},
+		{"10.0.0.1:443 load=0.5 id=abc", false},
+	}
+	for _, tt := range tests {
+		_, err := gr.RunWithTypeInfoStrict(tt.content, false)
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.content, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrFieldConstraint) {
+			t.Errorf("%s: expected ErrFieldConstraint, got %v", tt.content, err)
+		}
+	}
+
+	// Only the strict run checks ranges
+	if values, err := gr.RunWithTypeInfo("10.0.0.1:70000 load=0.5 id=150", false); err != nil || values[1] != int64(70000) {
+		t.Errorf("unexpected result %v %v", values, err)
+	}
+
+	recompiled, err := gr.Recompile(storage)
+	if err != nil {
+		t.Fatalf("Recompile failed: %v", err)
+	}
+	if _, err := recompiled.RunWithTypeInfoStrict("10.0.0.1:70000 load=0.5 id=150", false); !errors.Is(err, ErrFieldConstraint) {
+		t.Errorf("expected the range to be carried over, got %v", err)
+	}
+
+	gr.Reset()
+	if _, err := gr.RunWithTypeInfoStrict("10.0.0.1:70000 load=0.5 id=abc", false); err != nil {
+		t.Errorf("unexpected error after Reset: %v", err)
+	}
+
+	// Fields that do not participate in the match are not checked, an empty
+	// value that does is not a number
+	optional, err := CompilePattern(`^%{WORD:w}(?: port=%{INT:port:int})?(?: id=(?P<id>\d*))?$`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	optional.AddRangeConstraint("port", 1, 65535)
+	optional.AddRangeConstraint("id", 100, 200)
+	for _, tt := range []struct {
+		content string
+		valid   bool
+	}{
+		{"abc", true},
+		{"abc port=443 id=150", true},
+		{"abc port=0", false},
+		{"abc id=", false},
+	} {
+		_, err := optional.RunWithTypeInfoStrict(tt.content, false)
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.content, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrFieldConstraint) {
+			t.Errorf("%s: expected ErrFieldConstraint, got %v", tt.content, err)
+		}
+	}
+}
+
+func TestGrokRegexpFieldAt(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{SYSLOGPROG:prog}: %{WORD:verb} %{INT:status}(?: %{WORD:extra})?`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	gr.Rename("verb", "method")
+
+	content := "sshd[42]: GET 200"
+	tests := []struct {
+		offset int
+		name   string
+		ok     bool
+	}{
+		{0, "program", true},
+		{3, "program", true},
+		{4, "prog", true},
+		{5, "pid", true},
+		{6, "pid", true},
+		{7, "prog", true},
+		{8, "", false},
+		{10, "method", true},
+		{13, "", false},
+		{16, "status", true},
+		{17, "", false},
+		{-1, "", false},
+	}
+	for _, tt := range tests {
+		name, ok, err := gr.FieldAt(content, tt.offset)
+		if err != nil || name != tt.name || ok != tt.ok {
+			t.Errorf("offset %d: expected %q %v, got %q %v %v", tt.offset, tt.name, tt.ok, name, ok, err)
+		}
+	}
+
+	if _, _, err := gr.FieldAt("nothing here", 0); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+}
+
+func TestGrokRegexpUnknownType(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	if _, err := CompilePattern(`%{INT:n:itn}`, storage); err == nil {
+		t.Error("Expected DenormalizePattern to reject the unknown type")
+	}
+
+	gr, err := CompilePattern(`%{WORD:verb} %{INT:n:int}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	gr.grokPattern.varbType["n"] = "itn"
+
+	_, err = gr.RunWithTypeInfoStrict("GET 42", false)
+	if !errors.Is(err, ErrUnknownType) {
+		t.Fatalf("Expected ErrUnknownType, got %v", err)
+	}
+	var convErr *ConversionError
+	if !errors.As(err, &convErr) || convErr.Field != "n" || convErr.Type != "itn" || !errors.Is(err, ErrConversion) {
+		t.Errorf("Expected a *ConversionError for n, got %v", err)
+	}
+
+	if v, ok := gr.GetValCastByName("n", []string{"GET", "42"}); ok || v != nil {
+		t.Errorf("Expected GetValCastByName to fail, got %v %v", v, ok)
+	}
+}
+
+func TestGrokRegexpNumericPrefixAlias(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	// The regexp package accepts capture names starting with a digit, the
+	// alias is used as is
+	gr, err := CompilePattern(`%{NUMBER:2xx:int} %{NUMBER:5xx-rate:float}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if names := strings.Join(gr.MatchNames(), ","); names != "2xx,5xx_rate" {
+		t.Errorf("unexpected match names %s", names)
+	}
+
+	values, err := gr.Run("120 0.5", false)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if v, ok := gr.GetValByName("2xx", values); !ok || v != "120" {
+		t.Errorf("expected 2xx to be 120, got %q %v", v, ok)
+	}
+	if v, ok := gr.GetValCastByName("5xx_rate", values); !ok || v != 0.5 {
+		t.Errorf("expected 5xx_rate to be 0.5, got %v %v", v, ok)
+	}
+
+	j, err := gr.RunJSON("120 0.5", false)
+	if err != nil || string(j) != `{"2xx":120,"5xx_rate":0.5}` {
+		t.Errorf("unexpected JSON %s %v", j, err)
+	}
+}
+
+func TestGrokRegexpRunStream(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern(`%{WORD:verb} %{IP:client}(?: %{INT:bytes})? %{WORD:user}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	gr.Rename("client", "ip")
+
+	var got []string
+	err = gr.RunStream("GET 10.0.0.1 alice", false, func(name, value string) error {
+		got = append(got, name+"="+value)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("RunStream failed: %v", err)
+	}
+	if strings.Join(got, " ") != "verb=GET ip=10.0.0.1 bytes= user=alice" {
+		t.Errorf("unexpected fields %v", got)
+	}
+
+	stop := errors.New("stop")
+	got = nil
+	err = gr.RunStream("GET 10.0.0.1 512 alice", false, func(name, value string) error {
+		got = append(got, name)
+		if name == "ip" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || strings.Join(got, ",") != "verb,ip" {
+		t.Errorf("expected RunStream to stop after ip, got %v %v", got, err)
+	}
+
+	gr.AddFieldPattern("bytes", regexp.MustCompile(`^[0-9]{1,3}$`))
+	got = nil
+	err = gr.RunStream("GET 10.0.0.1 5120 alice", false, func(name, value string) error {
+		got = append(got, name)
+		return nil
+	})
+	if !errors.Is(err, ErrFieldConstraint) || strings.Join(got, ",") != "verb,ip" {
+		t.Errorf("expected ErrFieldConstraint after ip, got %v %v", got, err)
+	}
+
+	if err := gr.RunStream("nothing", false, func(string, string) error { return nil }); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+}
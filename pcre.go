@@ -0,0 +1,62 @@
+package grok
+
+import (
+	"strings"
+)
+
+// ToPCRE returns the denormalized regular expression in the syntax of PCRE,
+// for use by systems outside of Go such as PostgreSQL or JavaScript. Named
+// groups (?P<name>...) are written (?<name>...) and the \v escape, a vertical
+// tab in Go, is written \x0B, the rest of the expression being left as is.
+//
+// Some constructs keep their syntax but not their exact meaning:
+//   - $ outside of multi-line mode only matches at the end of the text in Go,
+//     PCRE also matches it before a final newline
+//   - \s does not match the vertical tab in Go, PCRE matches it
+//   - \pN, \p{Greek} and the other Unicode classes need the UTF mode of PCRE
+//   - the (?U) flag swapping greedy and lazy quantifiers is not supported by
+//     JavaScript
+func (g *GrokPattern) ToPCRE() string {
+	expr := g.denormalized
+	var b strings.Builder
+	b.Grow(len(expr))
+	for i := 0; i < len(expr); {
+		switch {
+		case expr[i] == '\\':
+			i = writePCREEscape(&b, expr, i)
+		case expr[i] == '[':
+			end := skipClass(expr, i)
+			for j := i; j <= end && j < len(expr); {
+				if expr[j] == '\\' {
+					j = writePCREEscape(&b, expr, j)
+					continue
+				}
+				b.WriteByte(expr[j])
+				j++
+			}
+			i = end + 1
+		case strings.HasPrefix(expr[i:], "(?P<"):
+			b.WriteString("(?<")
+			i += len("(?P<")
+		default:
+			b.WriteByte(expr[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+// writePCREEscape writes the escape sequence starting at offset i of expr to
+// b and returns the offset following it
+func writePCREEscape(b *strings.Builder, expr string, i int) int {
+	if i+1 >= len(expr) {
+		b.WriteByte('\\')
+		return i + 1
+	}
+	if expr[i+1] == 'v' {
+		b.WriteString(`\x0B`)
+	} else {
+		b.WriteString(expr[i : i+2])
+	}
+	return i + 2
+}
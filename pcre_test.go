@@ -0,0 +1,32 @@
+package grok
+
+import (
+	"testing"
+)
+
+func TestGrokPatternToPCRE(t *testing.T) {
+	storage := PatternStorage{map[string]*GrokPattern{
+		"NUM":  {pattern: `\d+`, denormalized: `\d+`},
+		"WORD": {pattern: `\b\w+\b`, denormalized: `\b\w+\b`},
+	}}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`%{WORD:verb} %{NUM:n}`, `(?<verb>\b\w+\b) (?<n>\d+)`},
+		{`^%{WORD}\s+(?P<rest>.*)$`, `^(\b\w+\b)\s+(?<rest>.*)$`},
+		{`[(?P<]\(?P<x>%{NUM:y}`, `[(?P<]\(?P<x>(?<y>\d+)`},
+		{`a\vb[\v\]]\\v`, `a\x0Bb[\x0B\]]\\v`},
+		{`(?i)%{WORD:w}(?:x|y)\z`, `(?i)(?<w>\b\w+\b)(?:x|y)\z`},
+	}
+	for _, tt := range tests {
+		gp, err := DenormalizePattern(tt.input, storage)
+		if err != nil {
+			t.Fatalf("DenormalizePattern %s failed: %v", tt.input, err)
+		}
+		if got := gp.ToPCRE(); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.input, tt.expected, got)
+		}
+	}
+}
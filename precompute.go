@@ -0,0 +1,87 @@
+package grok
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// PrecomputeDefaults returns the denormalized regular expression of each
+// default pattern, keyed by pattern name, so that it can be computed once at
+// build time instead of at every start of a program. The default patterns
+// that fail to denormalize are left out.
+//
+// The workflow is to generate a Go source file holding the map with
+// WritePrecomputedSource, from a small program run by go generate:
+//
+//	//go:generate go run ./gen -o grok_defaults.go
+//
+// where ./gen calls
+//
+//	grok.WritePrecomputedSource(f, "main", "grokDefaults", grok.PrecomputeDefaults())
+//
+// and to build the storage from the generated variable with
+// PrecomputedStorage(grokDefaults). The map can as well be saved with
+// encoding/json to a file embedded with //go:embed and decoded at start.
+// The map must be generated again when the package is upgraded
+func PrecomputeDefaults() map[string]string {
+	denormalized, _ := DenormalizePatternsFromMap(CopyDefalutPatterns())
+	ret := make(map[string]string, len(denormalized))
+	for name, gP := range denormalized {
+		ret[name] = gP.denormalized
+	}
+	return ret
+}
+
+// PrecomputedStorage returns a PatternStorage of the patterns whose
+// denormalized regular expressions are given by m, keyed by pattern name, as
+// returned by PrecomputeDefaults. The expressions are used as is, without
+// being denormalized or checked again. Only the regular expressions are kept,
+// so the types declared by the patterns of m and their FieldSyntax are lost:
+// the type annotations of the pattern compiled against the storage still
+// apply
+func PrecomputedStorage(m map[string]string) PatternStorage {
+	storage := make(map[string]*GrokPattern, len(m))
+	for name, expr := range m {
+		original, ok := patterns[name]
+		if !ok {
+			original = expr
+		}
+		storage[name] = &GrokPattern{
+			pattern:      original,
+			denormalized: expr,
+			varbType:     map[string]string{},
+			fieldSyntax:  map[string]string{},
+		}
+	}
+	return PatternStorage{storage}
+}
+
+// WritePrecomputedSource writes to w a gofmt formatted Go source file of
+// package pkg declaring the variable name as the map m, sorted by key, e.g.
+// the result of PrecomputeDefaults
+func WritePrecomputedSource(w io.Writer, pkg, name string, m map[string]string) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by grok.WritePrecomputedSource. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\nvar %s = map[string]string{\n", pkg, name)
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "%s: %s,\n", strconv.Quote(k), strconv.Quote(m[k]))
+	}
+	buf.WriteString("}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("precomputed source: %w", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
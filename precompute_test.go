@@ -0,0 +1,67 @@
+package grok
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func TestPrecomputeDefaults(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+
+	m := PrecomputeDefaults()
+	if len(m) != len(denormalized) {
+		t.Errorf("expected %d patterns, got %d", len(denormalized), len(m))
+	}
+	for name, gP := range denormalized {
+		if m[name] != gP.Denormalized() {
+			t.Errorf("%s: unexpected expression %q", name, m[name])
+		}
+	}
+
+	storage := PrecomputedStorage(m)
+	gr, err := CompilePattern(`%{COMMONAPACHELOG} took=%{NUMBER:took:float}`, storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	values, err := gr.RunMapWithTypeInfo(`127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 took=0.5`, false)
+	if err != nil {
+		t.Fatalf("RunMapWithTypeInfo failed: %v", err)
+	}
+	if values["clientip"] != "127.0.0.1" || values["verb"] != "GET" || values["took"] != 0.5 {
+		t.Errorf("unexpected values %v", values)
+	}
+
+	if gP, ok := storage.GetPattern("USER"); !ok || gP.Pattern() != `%{USERNAME}` {
+		t.Errorf("expected the original definition of USER, got %v", gP)
+	}
+}
+
+func TestWritePrecomputedSource(t *testing.T) {
+	var buf bytes.Buffer
+	err := WritePrecomputedSource(&buf, "main", "grokDefaults", map[string]string{
+		"WORD":  `\b\w+\b`,
+		"QUOTE": "\"[^\"]*\"`",
+	})
+	if err != nil {
+		t.Fatalf("WritePrecomputedSource failed: %v", err)
+	}
+
+	src := buf.String()
+	if !strings.HasPrefix(src, "// Code generated by grok.WritePrecomputedSource. DO NOT EDIT.") {
+		t.Errorf("missing generated code header:\n%s", src)
+	}
+	if strings.Index(src, `"QUOTE"`) > strings.Index(src, `"WORD"`) {
+		t.Errorf("expected sorted keys:\n%s", src)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "grok_defaults.go", src, 0); err != nil {
+		t.Errorf("generated source does not parse: %v\n%s", err, src)
+	}
+
+	if err := WritePrecomputedSource(&buf, "not a package", "x", nil); err == nil {
+		t.Error("expected an error for an invalid package name")
+	}
+}
@@ -0,0 +1,258 @@
+package grok
+
+import (
+	"fmt"
+	"regexp"
+	resyntax "regexp/syntax"
+	"strings"
+	"unicode"
+)
+
+// maxSampleDepth bounds the recursion through pattern references when
+// generating a sample
+const maxSampleDepth = 32
+
+// samples holds hand picked values for common default patterns
+var samples = map[string]string{
+	"USERNAME":          "user",
+	"USER":              "user",
+	"EMAILADDRESS":      "user@example.com",
+	"INT":               "123",
+	"BASE10NUM":         "123",
+	"NUMBER":            "123",
+	"BASE16NUM":         "0x1f",
+	"POSINT":            "123",
+	"NONNEGINT":         "123",
+	"WORD":              "foo",
+	"NOTSPACE":          "foo",
+	"SPACE":             " ",
+	"DATA":              "foo",
+	"GREEDYDATA":        "foo bar",
+	"QUOTEDSTRING":      `"foo"`,
+	"QS":                `"foo"`,
+	"UUID":              "123e4567-e89b-12d3-a456-426614174000",
+	"MAC":               "00:11:22:33:44:55",
+	"IPV6":              "2001:db8::1",
+	"IPV4":              "10.0.0.1",
+	"IP":                "10.0.0.1",
+	"HOSTNAME":          "example.com",
+	"HOST":              "example.com",
+	"IPORHOST":          "10.0.0.1",
+	"HOSTPORT":          "10.0.0.1:8080",
+	"PATH":              "/var/log/messages",
+	"UNIXPATH":          "/var/log/messages",
+	"URIPATH":           "/index.html",
+	"URIPATHPARAM":      "/index.html?foo=bar",
+	"URI":               "http://example.com/index.html",
+	"MONTH":             "Jan",
+	"MONTHNUM":          "01",
+	"MONTHNUM2":         "01",
+	"MONTHDAY":          "02",
+	"DAY":               "Mon",
+	"YEAR":              "2006",
+	"HOUR":              "15",
+	"MINUTE":            "04",
+	"SECOND":            "05",
+	"TIME":              "15:04:05",
+	"TIMESTAMP_ISO8601": "2006-01-02T15:04:05Z",
+	"HTTPDATE":          "02/Jan/2006:15:04:05 +0000",
+	"SYSLOGTIMESTAMP":   "Jan  2 15:04:05",
+	"PROG":              "sshd",
+	"LOGLEVEL":          "INFO",
+}
+
+// GenerateSample returns a string that plausibly matches the pattern.
+// Common default patterns produce hand picked values, other patterns are
+// generated from their regular expression by taking the shortest repetition
+// and the first alternative of every construct. This is a best-effort helper
+// intended for documentation and testing, the result is not guaranteed to
+// match patterns relying on word boundaries or anchors
+func GenerateSample(input string, storage PatternStorageIface) (string, error) {
+	return generateSample(input, storage, 0)
+}
+
+func generateSample(input string, storage PatternStorageIface, depth int) (string, error) {
+	if depth > maxSampleDepth {
+		return "", fmt.Errorf("pattern `%s`: too many nested references", input)
+	}
+
+	var expr strings.Builder
+	last := 0
+	for _, loc := range normalPattern.FindAllStringSubmatchIndex(input, -1) {
+		ref := input[loc[2]:loc[3]]
+		if !validPattern.MatchString(ref) {
+			return "", fmt.Errorf("invalid pattern `%%{%s}`", ref)
+		}
+		syntax := strings.Split(ref, ":")[0]
+
+		sample, ok := samples[syntax]
+		if !ok {
+			if storage == nil {
+				return "", fmt.Errorf("no pattern found for %%{%s}", syntax)
+			}
+			gP, found := storage.GetPattern(syntax)
+			if !found {
+				return "", fmt.Errorf("no pattern found for %%{%s}", syntax)
+			}
+			var err error
+			if sample, err = generateSample(gP.pattern, storage, depth+1); err != nil {
+				return "", err
+			}
+		}
+
+		expr.WriteString(input[last:loc[0]])
+		expr.WriteString("(?:")
+		expr.WriteString(regexp.QuoteMeta(sample))
+		expr.WriteString(")")
+		last = loc[1]
+	}
+	expr.WriteString(input[last:])
+
+	re, err := resyntax.Parse(expr.String(), resyntax.Perl)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	writeSample(re, &b)
+	return b.String(), nil
+}
+
+// writeSample writes the shortest string matched by re, choosing the first
+// branch of alternations
+func writeSample(re *resyntax.Regexp, b *strings.Builder) {
+	switch re.Op {
+	case resyntax.OpLiteral:
+		b.WriteString(string(re.Rune))
+	case resyntax.OpCharClass:
+		b.WriteRune(sampleRune(re.Rune))
+	case resyntax.OpAnyChar, resyntax.OpAnyCharNotNL:
+		// A non-word character keeps the word boundaries around it
+		b.WriteByte('.')
+	case resyntax.OpCapture, resyntax.OpPlus:
+		writeSample(re.Sub[0], b)
+	case resyntax.OpRepeat:
+		for i := 0; i < re.Min; i++ {
+			writeSample(re.Sub[0], b)
+		}
+	case resyntax.OpConcat:
+		for _, sub := range re.Sub {
+			writeSample(sub, b)
+		}
+	case resyntax.OpAlternate:
+		writeSample(re.Sub[0], b)
+	}
+}
+
+// sampleRune picks a readable rune from a character class given as a list of
+// inclusive ranges
+func sampleRune(ranges []rune) rune {
+	in := func(r rune) bool {
+		for i := 0; i+1 < len(ranges); i += 2 {
+			if ranges[i] <= r && r <= ranges[i+1] {
+				return true
+			}
+		}
+		return false
+	}
+	for _, r := range "ax0A1_-. " {
+		if in(r) {
+			return r
+		}
+	}
+	for i := 0; i+1 < len(ranges); i += 2 {
+		for r := ranges[i]; r <= ranges[i+1]; r++ {
+			if unicode.IsPrint(r) {
+				return r
+			}
+		}
+	}
+	if len(ranges) > 0 {
+		return ranges[0]
+	}
+	return 'x'
+}
+
+// maxOverlapSamples bounds the number of sample strings generated from each
+// pattern by Overlaps
+const maxOverlapSamples = 64
+
+// Overlaps reports whether a and b appear to match some common input, e.g. to
+// warn that two patterns dispatching log lines are ambiguous. It is a
+// heuristic: sample strings are generated from the regular expression of each
+// pattern, taking the shortest form of repetitions and trying the alternatives
+// of alternations up to a limit, and each pattern is run against the samples
+// of the other. A true result is always backed by a string matched by both
+// patterns, but a false result does not prove the patterns are disjoint, since
+// the samples cover a small part of what a pattern matches
+func Overlaps(a, b *GrokRegexp) (bool, error) {
+	if a == nil || b == nil || a.re == nil || b.re == nil {
+		return false, ErrNotCompiled
+	}
+
+	for _, pair := range [][2]*GrokRegexp{{a, b}, {b, a}} {
+		re, err := resyntax.Parse(pair[0].grokPattern.denormalized, resyntax.Perl)
+		if err != nil {
+			return false, err
+		}
+		for _, sample := range overlapSamples(re.Simplify()) {
+			if pair[0].re.MatchString(sample) && pair[1].re.MatchString(sample) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// overlapSamples returns up to maxOverlapSamples strings matched by re
+func overlapSamples(re *resyntax.Regexp) []string {
+	switch re.Op {
+	case resyntax.OpLiteral:
+		return []string{string(re.Rune)}
+	case resyntax.OpCharClass:
+		return []string{string(sampleRune(re.Rune))}
+	case resyntax.OpAnyChar, resyntax.OpAnyCharNotNL:
+		return []string{"x"}
+	case resyntax.OpCapture, resyntax.OpPlus:
+		return overlapSamples(re.Sub[0])
+	case resyntax.OpStar, resyntax.OpQuest:
+		return append([]string{""}, overlapSamples(re.Sub[0])...)
+	case resyntax.OpRepeat:
+		ret := []string{""}
+		for i := 0; i < re.Min; i++ {
+			ret = crossSamples(ret, overlapSamples(re.Sub[0]))
+		}
+		return ret
+	case resyntax.OpConcat:
+		ret := []string{""}
+		for _, sub := range re.Sub {
+			ret = crossSamples(ret, overlapSamples(sub))
+		}
+		return ret
+	case resyntax.OpAlternate:
+		var ret []string
+		for _, sub := range re.Sub {
+			ret = append(ret, overlapSamples(sub)...)
+			if len(ret) >= maxOverlapSamples {
+				return ret[:maxOverlapSamples]
+			}
+		}
+		return ret
+	}
+	return []string{""}
+}
+
+// crossSamples returns the concatenations of every prefix with every suffix,
+// up to maxOverlapSamples strings
+func crossSamples(prefixes, suffixes []string) []string {
+	ret := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		for _, s := range suffixes {
+			if len(ret) == maxOverlapSamples {
+				return ret
+			}
+			ret = append(ret, p+s)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,114 @@
+package grok
+
+import "testing"
+
+func TestGenerateSample(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	tests := []string{
+		"%{IP:server} %{NUMBER:port:int} %{WORD:status}",
+		"%{COMMONAPACHELOG}",
+		"%{COMBINEDAPACHELOG}",
+		"%{SYSLOGBASE} %{GREEDYDATA:message}",
+		"%{HTTPD_ERRORLOG}",
+		"%{TIMESTAMP_ISO8601:ts} %{LOGLEVEL:level} %{UUID:id}",
+		`user=%{USERNAME:user} id=(?:\d{3}-[a-f]+)`,
+		"%{DATESTAMP_RFC2822}",
+	}
+
+	for _, pattern := range tests {
+		t.Run(pattern, func(t *testing.T) {
+			sample, err := GenerateSample(pattern, storage)
+			if err != nil {
+				t.Fatalf("GenerateSample failed: %v", err)
+			}
+
+			gr, err := CompilePattern(pattern, storage)
+			if err != nil {
+				t.Fatalf("Failed to compile pattern: %v", err)
+			}
+			if !gr.Match(sample) {
+				t.Errorf("Sample %q does not match %s", sample, pattern)
+			}
+		})
+	}
+}
+
+func TestGenerateSampleErrors(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	if _, err := GenerateSample("%{DOESNOTEXIST}", storage); err == nil {
+		t.Error("Expected error for an unknown pattern")
+	}
+	if _, err := GenerateSample("(", storage); err == nil {
+		t.Error("Expected error for an invalid regular expression")
+	}
+	if sample, err := GenerateSample("literal", nil); err != nil || sample != "literal" {
+		t.Errorf("GenerateSample(literal) = %q, %v", sample, err)
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{`^user=%{WORD:u}$`, `^user=%{USERNAME:u}$`, true},
+		{`^(?:GET|POST) %{URIPATH:path}$`, `^POST %{URIPATH:path}$`, true},
+		{`^GET %{URIPATH:path}$`, `^POST %{URIPATH:path}$`, false},
+		{`^%{INT:n}$`, `^%{WORD:w}x$`, false},
+	}
+
+	for _, tt := range tests {
+		a, err := CompilePattern(tt.a, storage)
+		if err != nil {
+			t.Fatalf("Failed to compile %s: %v", tt.a, err)
+		}
+		b, err := CompilePattern(tt.b, storage)
+		if err != nil {
+			t.Fatalf("Failed to compile %s: %v", tt.b, err)
+		}
+		got, err := Overlaps(a, b)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got != tt.expected {
+			t.Errorf("Overlaps(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.expected)
+		}
+	}
+
+	if _, err := Overlaps(&GrokRegexp{}, &GrokRegexp{}); err != ErrNotCompiled {
+		t.Errorf("Expected ErrNotCompiled, got %v", err)
+	}
+}
+
+func TestGenerateSampleDefaults(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	for name := range denormalized {
+		pattern := "%{" + name + "}"
+		sample, err := GenerateSample(pattern, storage)
+		if err != nil {
+			t.Errorf("%s: GenerateSample failed: %v", name, err)
+			continue
+		}
+		gr, err := CompilePattern(pattern, storage)
+		if err != nil {
+			t.Errorf("%s: failed to compile pattern: %v", name, err)
+			continue
+		}
+		if !gr.Match(sample) {
+			t.Errorf("%s: sample %q does not match", name, sample)
+		}
+	}
+}
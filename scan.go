@@ -0,0 +1,153 @@
+package grok
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// logTimeLayouts are tried when a value is in none of the layouts known to
+// the cast package
+var logTimeLayouts = []string{
+	"02/Jan/2006:15:04:05 -0700",
+	"Jan _2 15:04:05",
+	"2006-01-02 15:04:05,000",
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Scan matches content against the compiled pattern and stores the captured
+// values in the fields of the struct pointed to by dst. A struct field is
+// filled from the capture named by its `grok:"name"` tag, fields without a
+// tag, tagged "-" or unexported are ignored.
+//
+// Values are converted according to the kind of the struct field: string,
+// signed and unsigned integers, floats, bool, time.Time, and any type
+// implementing encoding.TextUnmarshaler. A field whose capture is not part of
+// the pattern or captured an empty string keeps its current value. Scan
+// returns ErrMismatch when content does not match, and an error naming the
+// field when a value cannot be converted
+func (g *GrokRegexp) Scan(content string, dst interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("scan destination must be a non-nil pointer to a struct")
+	}
+
+	values, err := g.Run(content, false)
+	if err != nil {
+		return err
+	}
+
+	sv := rv.Elem()
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		sf := st.Field(i)
+		name, ok := sf.Tag.Lookup("grok")
+		if !ok || name == "-" || sf.PkgPath != "" {
+			continue
+		}
+
+		value, ok := g.GetValByName(name, values)
+		if !ok || value == "" {
+			continue
+		}
+
+		if err := g.scanValue(sv.Field(i), value); err != nil {
+			return fmt.Errorf("field `%s`: cannot convert %q to %s: %w", sf.Name, value, sf.Type, err)
+		}
+	}
+
+	return nil
+}
+
+// scanValue converts value and stores it in the field
+func (g *GrokRegexp) scanValue(field reflect.Value, value string) error {
+	if field.Type() == timeType {
+		t, err := parseTime(value, g.opts.location)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if reflect.PtrTo(field.Type()).Implements(textUnmarshalerType) {
+		return field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := parseInt(g.normalizeNumber(value))
+		if err != nil {
+			return err
+		}
+		if field.OverflowInt(n) {
+			return errors.New("value out of range")
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(g.normalizeNumber(value), 10, 64)
+		if err != nil {
+			return err
+		}
+		if field.OverflowUint(n) {
+			return errors.New("value out of range")
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := cast.ToFloat64E(g.normalizeNumber(value))
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := cast.ToBoolE(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return errors.New("unsupported field type")
+	}
+	return nil
+}
+
+// parseTime parses a timestamp in any layout known to the cast package or in
+// one of the common log layouts. A timestamp without zone is taken to be in
+// loc and returned in UTC, a nil loc standing for UTC. A timestamp with a zone
+// keeps its offset
+func parseTime(value string, loc *time.Location) (time.Time, error) {
+	t, err := parseTimeIn(value, time.UTC)
+	if err != nil || loc == nil || loc == time.UTC {
+		return t, err
+	}
+	// Only a timestamp without zone depends on the default location
+	local, err := parseTimeIn(value, loc)
+	if err != nil || local.Equal(t) {
+		return t, nil
+	}
+	return local.UTC(), nil
+}
+
+// parseTimeIn parses a timestamp like parseTime, in loc when it has no zone
+func parseTimeIn(value string, loc *time.Location) (time.Time, error) {
+	t, err := cast.ToTimeInDefaultLocationE(value, loc)
+	if err == nil {
+		return t, nil
+	}
+	for _, layout := range logTimeLayouts {
+		if t, lerr := time.ParseInLocation(layout, value, loc); lerr == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
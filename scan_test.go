@@ -0,0 +1,130 @@
+package grok
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type accessLog struct {
+	ClientIP  string    `grok:"clientip"`
+	Verb      string    `grok:"verb"`
+	Response  int       `grok:"response"`
+	Bytes     uint32    `grok:"bytes"`
+	Version   float64   `grok:"httpversion"`
+	Timestamp time.Time `grok:"timestamp"`
+	Raw       string    `grok:"rawrequest"`
+	Ignored   string    `grok:"-"`
+	Untagged  string
+	missing   string `grok:"verb"`
+}
+
+func TestGrokRegexpScan(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern("%{COMMONAPACHELOG}", storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	dst := accessLog{Raw: "kept", Ignored: "kept", Untagged: "kept"}
+	err = gr.Scan(`127.0.0.1 - - [23/Apr/2014:22:58:32 +0200] "GET /index.php HTTP/1.1" 404 207`, &dst)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	if dst.ClientIP != "127.0.0.1" {
+		t.Errorf("ClientIP = %q, want %q", dst.ClientIP, "127.0.0.1")
+	}
+	if dst.Verb != "GET" {
+		t.Errorf("Verb = %q, want %q", dst.Verb, "GET")
+	}
+	if dst.Response != 404 {
+		t.Errorf("Response = %d, want 404", dst.Response)
+	}
+	if dst.Bytes != 207 {
+		t.Errorf("Bytes = %d, want 207", dst.Bytes)
+	}
+	if !dst.Timestamp.Equal(time.Date(2014, 4, 23, 20, 58, 32, 0, time.UTC)) {
+		t.Errorf("Timestamp = %v", dst.Timestamp)
+	}
+	if dst.Version != 1.1 {
+		t.Errorf("Version = %v, want 1.1", dst.Version)
+	}
+	if dst.Raw != "kept" || dst.Ignored != "kept" || dst.Untagged != "kept" || dst.missing != "" {
+		t.Errorf("Fields without a value should be left untouched: %+v", dst)
+	}
+
+	gr, _ = CompilePattern("%{TIMESTAMP_ISO8601:timestamp} %{IP:ip} %{WORD:ok}", storage)
+	var event struct {
+		Timestamp time.Time `grok:"timestamp"`
+		IP        net.IP    `grok:"ip"`
+		OK        bool      `grok:"ok"`
+	}
+	if err := gr.Scan("2014-04-23T22:58:32Z 10.0.0.1 true", &event); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if !event.Timestamp.Equal(time.Date(2014, 4, 23, 22, 58, 32, 0, time.UTC)) {
+		t.Errorf("Timestamp = %v", event.Timestamp)
+	}
+	if !event.IP.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("IP = %v", event.IP)
+	}
+	if !event.OK {
+		t.Error("OK should be true")
+	}
+}
+
+func TestGrokRegexpScanErrors(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, _ := CompilePattern("%{WORD:word} %{NUMBER:num}", storage)
+
+	var dst struct {
+		Word int  `grok:"word"`
+		Num  int8 `grok:"num"`
+	}
+	if err := gr.Scan("abc 1", dst); err == nil {
+		t.Error("Expected error for a non pointer destination")
+	}
+	if err := gr.Scan("abc 1", &dst); err == nil {
+		t.Error("Expected conversion error")
+	}
+	if err := gr.Scan("", &dst); err != ErrMismatch {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+
+	var overflow struct {
+		Num int8 `grok:"num"`
+	}
+	if err := gr.Scan("abc 1000", &overflow); err == nil {
+		t.Error("Expected out of range error")
+	}
+}
+
+func TestGrokRegexpScanZeroPadded(t *testing.T) {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	gr, err := CompilePattern("%{HOUR:hour}:%{MINUTE:minute} %{INT:day}", storage)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+
+	var dst struct {
+		Hour   int    `grok:"hour"`
+		Minute uint8  `grok:"minute"`
+		Day    uint64 `grok:"day"`
+	}
+	if err := gr.Scan("08:09 010", &dst); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if dst.Hour != 8 || dst.Minute != 9 || dst.Day != 10 {
+		t.Errorf("Zero padded values should be decimal, got %+v", dst)
+	}
+}
@@ -0,0 +1,129 @@
+package grok
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// GrokSet holds compiled patterns registered under a name, e.g. one pattern
+// per kind of line of a log. The patterns are tried in the order they were
+// added. A GrokSet must not be modified concurrently with its match methods
+type GrokSet struct {
+	names         []string
+	patterns      map[string]*GrokRegexp
+	discriminator *GrokRegexp
+}
+
+// NewGrokSet returns an empty GrokSet
+func NewGrokSet() *GrokSet {
+	return &GrokSet{patterns: map[string]*GrokRegexp{}}
+}
+
+// Add registers g under name, replacing the pattern already registered under
+// that name while keeping its position
+func (s *GrokSet) Add(name string, g *GrokRegexp) {
+	if _, ok := s.patterns[name]; !ok {
+		s.names = append(s.names, name)
+	}
+	s.patterns[name] = g
+}
+
+// Get returns the pattern registered under name
+func (s *GrokSet) Get(name string) (*GrokRegexp, bool) {
+	g, ok := s.patterns[name]
+	return g, ok
+}
+
+// Names returns the names of the registered patterns in the order they are
+// tried
+func (s *GrokSet) Names() []string {
+	return append([]string(nil), s.names...)
+}
+
+// Match runs the registered patterns in order against content and returns
+// the name and the fields of the first one that matches. It returns
+// ErrMismatch when none matches
+func (s *GrokSet) Match(content string, trimSpace bool) (string, map[string]string, error) {
+	for _, name := range s.names {
+		fields, err := s.patterns[name].RunMap(content, trimSpace)
+		if err == nil {
+			return name, fields, nil
+		}
+		if !errors.Is(err, ErrMismatch) && !errors.Is(err, ErrFieldConstraint) {
+			return name, nil, err
+		}
+	}
+	return "", nil, ErrMismatch
+}
+
+// SetDiscriminator sets the pattern MatchByDiscriminator uses to extract the
+// discriminator from the content. It is usually a short pattern capturing
+// only the start of the line, such as `%{WORD:kind} `
+func (s *GrokSet) SetDiscriminator(g *GrokRegexp) {
+	s.discriminator = g
+}
+
+// MatchByDiscriminator extracts the value of discriminatorField with the
+// pattern set by SetDiscriminator, then runs the pattern registered under
+// that value against content. It returns the discriminator value and the
+// fields of the dispatched pattern. It returns ErrMismatch when either pattern
+// does not match, and ErrUnknownDiscriminator when no pattern is registered
+// for the value
+func (s *GrokSet) MatchByDiscriminator(content string, discriminatorField string) (string, map[string]string, error) {
+	if s.discriminator == nil {
+		return "", nil, errors.New("no discriminator pattern set")
+	}
+
+	values, err := s.discriminator.Run(content, false)
+	if err != nil {
+		return "", nil, err
+	}
+	value, ok := s.discriminator.GetValByName(discriminatorField, values)
+	if !ok {
+		return "", nil, fmt.Errorf("discriminator pattern has no field %s", discriminatorField)
+	}
+
+	g, ok := s.patterns[value]
+	if !ok {
+		return value, nil, fmt.Errorf("%w: %q", ErrUnknownDiscriminator, value)
+	}
+	fields, err := g.RunMap(content, false)
+	if err != nil {
+		return value, nil, err
+	}
+	return value, fields, nil
+}
+
+// RunReader reads r line by line and matches each line against the registered
+// patterns like Match. fn is called for every line with the name and the
+// fields of the pattern that matched, or with ErrMismatch when none did, and
+// reading stops when it returns false. Line terminators are not part of the
+// line. The input can be split into other records with WithSplitter, fn then
+// being called for every record. RunReader returns the error met while
+// reading r, if any
+func (s *GrokSet) RunReader(r io.Reader, fn func(line string, matchedName string, fields map[string]string, err error) bool, opts ...ReaderOption) error {
+	o := newReaderOptions(opts)
+
+	scanner := bufio.NewScanner(r)
+	if o.bufferSize > 0 {
+		initial := 4096
+		if o.bufferSize < initial {
+			initial = o.bufferSize
+		}
+		scanner.Buffer(make([]byte, 0, initial), o.bufferSize)
+	}
+	if o.split != nil {
+		scanner.Split(o.split)
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		name, fields, err := s.Match(line, false)
+		if !fn(line, name, fields, err) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,196 @@
+package grok
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newTestGrokSet(t *testing.T, patterns map[string]string, order ...string) *GrokSet {
+	defaultPatterns := CopyDefalutPatterns()
+	denormalized, _ := DenormalizePatternsFromMap(defaultPatterns)
+	storage := PatternStorage{denormalized}
+
+	set := NewGrokSet()
+	for _, name := range order {
+		gr, err := CompilePattern(patterns[name], storage, WithAnchorStart(), WithAnchorEnd())
+		if err != nil {
+			t.Fatalf("Failed to compile pattern %s: %v", name, err)
+		}
+		set.Add(name, gr)
+	}
+	return set
+}
+
+func TestGrokSetMatch(t *testing.T) {
+	set := newTestGrokSet(t, map[string]string{
+		"access": `%{IP:client} %{WORD:method} %{URIPATH:path}`,
+		"error":  `%{LOGLEVEL:level}: %{GREEDYDATA:message}`,
+		"any":    `%{GREEDYDATA:line}`,
+	}, "access", "error", "any")
+
+	tests := []struct {
+		content  string
+		name     string
+		field    string
+		expected string
+	}{
+		{"10.0.0.1 GET /index.html", "access", "path", "/index.html"},
+		{"ERROR: disk full", "error", "message", "disk full"},
+		{"something else", "any", "line", "something else"},
+	}
+	for _, tt := range tests {
+		name, fields, err := set.Match(tt.content, false)
+		if err != nil {
+			t.Fatalf("%q: Match failed: %v", tt.content, err)
+		}
+		if name != tt.name || fields[tt.field] != tt.expected {
+			t.Errorf("%q: got %s %v, want %s with %s=%q", tt.content, name, fields, tt.name, tt.field, tt.expected)
+		}
+	}
+
+	if names := set.Names(); len(names) != 3 || names[0] != "access" || names[2] != "any" {
+		t.Errorf("unexpected names %v", names)
+	}
+
+	strict := newTestGrokSet(t, map[string]string{"num": `%{INT:n}`}, "num")
+	if _, _, err := strict.Match("abc", false); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected ErrMismatch, got %v", err)
+	}
+}
+
+func TestGrokSetMatchByDiscriminator(t *testing.T) {
+	patterns := map[string]string{
+		"kind":  `%{WORD:kind} .*`,
+		"LOGIN": `LOGIN %{USERNAME:user} from %{IP:ip}`,
+		"XFER":  `XFER %{INT:bytes} bytes to %{HOSTNAME:host}`,
+	}
+	set := newTestGrokSet(t, patterns, "kind", "LOGIN", "XFER")
+	disc, _ := set.Get("kind")
+	set.SetDiscriminator(disc)
+
+	kind, fields, err := set.MatchByDiscriminator("XFER 512 bytes to example.com", "kind")
+	if err != nil {
+		t.Fatalf("MatchByDiscriminator failed: %v", err)
+	}
+	if kind != "XFER" || fields["bytes"] != "512" || fields["host"] != "example.com" {
+		t.Errorf("unexpected result %s %v", kind, fields)
+	}
+
+	kind, fields, err = set.MatchByDiscriminator("LOGIN alice from 10.0.0.1", "kind")
+	if err != nil {
+		t.Fatalf("MatchByDiscriminator failed: %v", err)
+	}
+	if kind != "LOGIN" || fields["user"] != "alice" || fields["ip"] != "10.0.0.1" {
+		t.Errorf("unexpected result %s %v", kind, fields)
+	}
+
+	if kind, _, err := set.MatchByDiscriminator("LOGOUT alice", "kind"); !errors.Is(err, ErrUnknownDiscriminator) || kind != "LOGOUT" {
+		t.Errorf("expected ErrUnknownDiscriminator for LOGOUT, got %q %v", kind, err)
+	}
+	if _, _, err := set.MatchByDiscriminator("LOGIN alice from nowhere", "kind"); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected ErrMismatch, got %v", err)
+	}
+	if _, _, err := set.MatchByDiscriminator("!!", "kind"); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected ErrMismatch for the discriminator, got %v", err)
+	}
+	if _, _, err := set.MatchByDiscriminator("XFER 1 bytes to a", "type"); err == nil {
+		t.Error("expected an error for an unknown discriminator field")
+	}
+	if _, _, err := NewGrokSet().MatchByDiscriminator("XFER", "kind"); err == nil {
+		t.Error("expected an error without discriminator pattern")
+	}
+}
+
+func TestGrokSetRunReader(t *testing.T) {
+	set := newTestGrokSet(t, map[string]string{
+		"access": `%{IP:client} %{WORD:method} %{URIPATH:path}`,
+		"error":  `%{LOGLEVEL:level}: %{GREEDYDATA:message}`,
+	}, "access", "error")
+
+	input := "10.0.0.1 GET /a\r\nERROR: disk full\n???\n\n10.0.0.2 POST /b"
+	var got []string
+	err := set.RunReader(strings.NewReader(input), func(line, name string, fields map[string]string, err error) bool {
+		switch {
+		case errors.Is(err, ErrMismatch):
+			got = append(got, "mismatch:"+line)
+		case err != nil:
+			t.Fatalf("unexpected error %v", err)
+		default:
+			got = append(got, name+":"+fields["client"]+fields["level"])
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatalf("RunReader failed: %v", err)
+	}
+	expected := "access:10.0.0.1 error:ERROR mismatch:??? mismatch: access:10.0.0.2"
+	if strings.Join(got, " ") != expected {
+		t.Errorf("expected %q, got %q", expected, strings.Join(got, " "))
+	}
+
+	lines := 0
+	err = set.RunReader(strings.NewReader(input), func(string, string, map[string]string, error) bool {
+		lines++
+		return lines < 2
+	})
+	if err != nil || lines != 2 {
+		t.Errorf("expected reading to stop after 2 lines, got %d %v", lines, err)
+	}
+
+	long := "10.0.0.1 GET /" + strings.Repeat("a", 200) + "\n"
+	err = set.RunReader(strings.NewReader(long), func(string, string, map[string]string, error) bool { return true }, WithBufferSize(100))
+	if !errors.Is(err, bufio.ErrTooLong) {
+		t.Errorf("expected bufio.ErrTooLong, got %v", err)
+	}
+	err = set.RunReader(strings.NewReader(strings.Repeat(long, 2)+"x"), func(line, name string, _ map[string]string, err error) bool {
+		if line != "x" && name != "access" {
+			t.Errorf("expected a long line to match, got %v", err)
+		}
+		return true
+	}, WithBufferSize(1024))
+	if err != nil {
+		t.Errorf("RunReader failed: %v", err)
+	}
+}
+
+func TestGrokSetRunReaderSplitter(t *testing.T) {
+	set := newTestGrokSet(t, map[string]string{
+		"access": `^%{IP:client} %{WORD:method} %{URIPATH:path}$`,
+		"error":  `^%{LOGLEVEL:level}: (?s:%{GREEDYDATA:message})$`,
+	}, "access", "error")
+
+	nul := func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+
+	input := "10.0.0.1 GET /a\x00ERROR: disk\nfull\x0010.0.0.2 POST /b"
+	var got []string
+	err := set.RunReader(strings.NewReader(input), func(record, name string, fields map[string]string, err error) bool {
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", record, err)
+		}
+		got = append(got, name+":"+fields["client"]+fields["message"])
+		return true
+	}, WithSplitter(nul))
+	if err != nil {
+		t.Fatalf("RunReader failed: %v", err)
+	}
+	expected := []string{"access:10.0.0.1", "error:disk\nfull", "access:10.0.0.2"}
+	if strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	err = set.RunReader(strings.NewReader(strings.Repeat("a", 200)), func(string, string, map[string]string, error) bool { return true }, WithSplitter(nul), WithBufferSize(100))
+	if !errors.Is(err, bufio.ErrTooLong) {
+		t.Errorf("expected bufio.ErrTooLong, got %v", err)
+	}
+}
@@ -2,63 +2,91 @@ package grok
 
 import (
 	"fmt"
+	"strings"
 )
 
+// neverMatch is substituted for a cyclic reference once the expansion depth
+// is exhausted, it is an empty character class that cannot match any input
+const neverMatch = `[^\x00-\x{10FFFF}]`
+
 // nodeP represents a pattern node in the dependency graph
 type nodeP struct {
-	cnt   string        // content: the pattern string
-	ptn   *GrokPattern  // pre-denormalized pattern (if available)
-	cNode []string      // child nodes: dependencies
+	cnt   string       // content: the pattern string
+	ptn   *GrokPattern // pre-denormalized pattern (if available)
+	cNode []string     // child nodes: dependencies
+	refs  [][]int      // locations of the references in cnt
 }
 
 // path tracks the current path through the dependency graph for cycle detection
 type path struct {
-	m map[string]struct{} // set of nodes in current path
-	l []string            // ordered list of nodes in current path
+	m map[string]int // occurrences of each node in current path
+	l []string       // ordered list of nodes in current path
 }
 
 // runTree processes the pattern dependency graph and returns denormalized patterns
 // Returns a map of successfully denormalized patterns and a map of errors
-func runTree(m map[string]*nodeP) (map[string]*GrokPattern, map[string]string) {
+func runTree(m map[string]*nodeP, opts denormalizeOptions) (map[string]*GrokPattern, map[string]string) {
 	ret := map[string]*GrokPattern{}
 	invalid := map[string]string{}
 	pt := &path{
-		m: map[string]struct{}{},
+		m: map[string]int{},
 		l: []string{},
 	}
-	
+
+	// Patterns whose expansion was cut are kept apart from ret, which also
+	// serves as the cache of complete patterns
+	cut := map[string]*GrokPattern{}
 	for name, v := range m {
-		if err := dfs(ret, m, name, v, pt); err != nil {
+		if ptn, complete, err := dfs(ret, m, name, v, pt, opts); err != nil {
 			invalid[name] = err.Error()
+		} else if !complete {
+			cut[name] = ptn
 		}
 	}
-	
+	for name, ptn := range cut {
+		ret[name] = ptn
+	}
+
 	return ret, invalid
 }
 
 // dfs performs depth-first search to resolve pattern dependencies
-func dfs(deP map[string]*GrokPattern, top map[string]*nodeP, startName string, start *nodeP, pt *path) error {
+// A node may appear up to opts.expandDepth times on the current path before
+// the cyclic reference is cut. The returned flag reports whether the pattern
+// was resolved without any cut, only such patterns are cached in deP since
+// the expansion of a cut pattern depends on where the walk started
+func dfs(deP map[string]*GrokPattern, top map[string]*nodeP, startName string, start *nodeP, pt *path, opts denormalizeOptions) (*GrokPattern, bool, error) {
 	// Check for circular dependency
-	if _, ok := pt.m[startName]; ok {
+	if n := pt.m[startName]; n > opts.expandDepth {
+		if opts.expandDepth > 0 {
+			return &GrokPattern{
+				pattern:      start.cnt,
+				denormalized: neverMatch,
+				varbType:     map[string]string{},
+			}, false, nil
+		}
+		if len(pt.l) > 0 && pt.l[len(pt.l)-1] == startName {
+			return nil, false, fmt.Errorf("pattern %s references itself", startName)
+		}
 		lineStr := ""
 		for _, k := range pt.l {
 			lineStr += k + " -> "
 		}
 		lineStr += startName
-		return fmt.Errorf("circular dependency: pattern %s", lineStr)
+		return nil, false, fmt.Errorf("circular dependency: pattern %s", lineStr)
 	}
 
 	// Add current node to path
-	pt.m[startName] = struct{}{}
+	pt.m[startName]++
 	pt.l = append(pt.l, startName)
 	defer func() {
-		delete(pt.m, startName)
+		pt.m[startName]--
 		pt.l = pt.l[:len(pt.l)-1]
 	}()
 
 	// If already denormalized, return early
-	if _, ok := deP[startName]; ok {
-		return nil
+	if ptn, ok := deP[startName]; ok {
+		return ptn, true, nil
 	}
 
 	// If this is a leaf node (no dependencies) or has a pre-denormalized pattern
@@ -66,36 +94,48 @@ func dfs(deP map[string]*GrokPattern, top map[string]*nodeP, startName string, s
 		if start.ptn != nil {
 			// Use the pre-denormalized pattern
 			deP[startName] = start.ptn
-			return nil
+			return start.ptn, true, nil
 		}
 		// Try to denormalize with what we have
-		if ptn, err := DenormalizePattern(start.cnt, PatternStorage{deP}); err != nil {
-			return err
-		} else {
-			deP[startName] = ptn
+		ptn, err := denormalizeReferences(start.cnt, start.refs, PatternStorage{deP}, opts)
+		if err != nil {
+			return nil, false, err
 		}
-		return nil
+		deP[startName] = ptn
+		return ptn, true, nil
 	}
 
 	// Process all dependencies first
+	resolved := map[string]*GrokPattern{}
+	complete := true
 	for _, name := range start.cNode {
 		cNode, ok := top[name]
 		if !ok || cNode == nil {
-			return fmt.Errorf("no pattern found for %%{%s}", name)
+			// Show how the pattern is reached when it is not a direct dependency
+			if len(pt.l) > 1 {
+				return nil, false, fmt.Errorf("no pattern found for %s: %s -> %s",
+					opts.formatReference(name), strings.Join(pt.l, " -> "), name)
+			}
+			return nil, false, fmt.Errorf("no pattern found for %s", opts.formatReference(name))
 		}
 
 		// Recursively denormalize the dependency
-		if err := dfs(deP, top, name, cNode, pt); err != nil {
-			return err
+		ptn, ok, err := dfs(deP, top, name, cNode, pt, opts)
+		if err != nil {
+			return nil, false, err
 		}
+		resolved[name] = ptn
+		complete = complete && ok
 	}
 
 	// Now denormalize this pattern with all dependencies available
-	if ptn, err := DenormalizePattern(start.cnt, PatternStorage{deP}); err != nil {
-		return err
-	} else {
+	ptn, err := denormalizeReferences(start.cnt, start.refs, PatternStorage{resolved, deP}, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	if complete {
 		deP[startName] = ptn
 	}
 
-	return nil
+	return ptn, complete, nil
 }